@@ -9,9 +9,18 @@ import (
 )
 
 func main() {
-	if len(os.Args) > 1 && os.Args[1] == "seed" {
-		database.SeedDB()
-		return
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "seed":
+			database.SeedDB()
+			return
+		case "import":
+			database.ImportUsers(os.Args[2:])
+			return
+		case "export":
+			database.ExportUsers(os.Args[2:])
+			return
+		}
 	}
 	server := server.NewServer()
 
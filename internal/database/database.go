@@ -82,4 +82,22 @@ func (s *dbclient) AutoMigrateAll(models []interface{}) {
 	for _, model := range models {
 		s.db.AutoMigrate(model)
 	}
+	s.createSearchIndex()
+}
+
+// createSearchIndex adds a trigram index for user search. It needs the
+// pg_trgm extension, which may not be available, search still works
+// without it, only slower.
+func (s *dbclient) createSearchIndex() {
+	statements := []string{
+		"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+		"CREATE INDEX IF NOT EXISTS idx_users_search ON users USING gin (" +
+			domain.UserSearchExpression + " gin_trgm_ops)",
+	}
+	for _, statement := range statements {
+		if err := s.db.Exec(statement).Error; err != nil {
+			log.Printf("user search index not created: %v", err)
+			return
+		}
+	}
 }
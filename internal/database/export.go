@@ -0,0 +1,56 @@
+package database
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/services"
+)
+
+// ExportUsers runs the export subcommand:
+//
+//	export [-format csv|ndjson] [-o FILE] [-role ROLE] [-state STATE] [-q TEXT] [-sort FIELD]
+//
+// The users are written to stdout unless -o is given.
+func ExportUsers(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", services.CSVFormat, "csv or ndjson")
+	out := flags.String("o", "", "write to this file instead of stdout")
+	filter := services.UserFilter{}
+	flags.Func("role", "only users with this role", func(v string) error {
+		filter.Role = domain.Role(v)
+		return nil
+	})
+	flags.StringVar(&filter.State, "state", "", "active, suspended, deleted or all")
+	flags.StringVar(&filter.Query, "q", "", "only users whose username, email or name contain this")
+	flags.StringVar(&filter.Sort, "sort", "", "sort field, prefixed with - for descending")
+	flags.Parse(args)
+
+	var env domain.Env
+	if err := envconfig.Process("", &env); err != nil {
+		log.Fatal(err.Error())
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	buf := bufio.NewWriter(w)
+	db := New(&env)
+	if err := services.NewUserExporter(db.GetClient()).Export(buf, *format, filter); err != nil {
+		log.Fatal(err)
+	}
+	if err := buf.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
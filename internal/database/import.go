@@ -0,0 +1,72 @@
+package database
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/services"
+)
+
+// ImportUsers runs the import subcommand:
+//
+//	import [-format csv|ndjson] [-dry-run] FILE
+//
+// FILE may be - for stdin. The format defaults to the file extension. The
+// report is written to stdout as JSON.
+func ImportUsers(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "csv or ndjson, by default taken from the file extension")
+	dryRun := flags.Bool("dry-run", false, "check the rows without writing them")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("usage: import [-format csv|ndjson] [-dry-run] FILE")
+	}
+	path := flags.Arg(0)
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		if *format == "jsonl" {
+			*format = services.NDJSONFormat
+		}
+	}
+
+	var env domain.Env
+	if err := envconfig.Process("", &env); err != nil {
+		log.Fatal(err.Error())
+	}
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	hasherConfig, err := services.NewPasswordHasherConfig(&env)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db := New(&env)
+	importer := services.NewUserImporter(db.GetClient(), services.NewPasswordHasher(hasherConfig))
+	report, err := importer.Import(in, *format, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf(
+		"%d rows: %d created, %d updated, %d failed",
+		report.Total, report.Created, report.Updated, report.Failed,
+	)
+}
@@ -8,11 +8,11 @@ import (
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
 	"github.com/ostheperson/go-auth-service/internal/domain"
 	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
 )
 
 type Seed struct {
@@ -27,8 +27,13 @@ func SeedDB() {
 		log.Fatal(err.Error())
 	}
 	db := New(&env)
+	hasherConfig, err := services.NewPasswordHasherConfig(&env)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	hasher := services.NewPasswordHasher(hasherConfig)
 
-	for _, seed := range All() {
+	for _, seed := range All(hasher) {
 		log.Printf("running %v seed", seed.Name)
 		if err := seed.Run(db.GetClient()); err != nil {
 			log.Fatalf("Running seed '%s', failed with error: %s", seed.Name, err)
@@ -38,6 +43,7 @@ func SeedDB() {
 
 func CreateUser(
 	db *gorm.DB,
+	hasher services.PasswordHasher,
 	firstname string,
 	lastname string,
 	email string,
@@ -45,19 +51,24 @@ func CreateUser(
 	password string,
 	role domain.Role,
 ) error {
-	hash, e := bcrypt.GenerateFromPassword([]byte(password), 10)
+	hash, e := hasher.Hash(password)
 	if e != nil {
 		return fmt.Errorf(helper.ErrFailHash)
 	}
+	// seeded passwords come from shared env vars, so make the owner pick
+	// their own at first sign-in
+	now := time.Now()
 	user := &domain.User{
-		Firstname:       firstname,
-		Lastname:        lastname,
-		Username:        username,
-		Email:           email,
-		Password:        string(hash),
-		Role:            role,
-		VerifiedAt:      time.Now(),
-		IsEmailVerified: true,
+		Firstname:          firstname,
+		Lastname:           lastname,
+		Username:           username,
+		Email:              email,
+		Password:           hash,
+		Role:               role,
+		VerifiedAt:         now,
+		IsEmailVerified:    true,
+		PasswordChangedAt:  &now,
+		MustChangePassword: true,
 	}
 	existingUser := domain.User{}
 	err := db.Where("email = ? OR username = ?", email, username).First(&existingUser).Error
@@ -72,19 +83,22 @@ func CreateUser(
 	existingUser.Email = user.Email
 	existingUser.Username = user.Username
 	existingUser.Password = user.Password
+	existingUser.PasswordChangedAt = user.PasswordChangedAt
+	existingUser.MustChangePassword = user.MustChangePassword
 	existingUser.Role = user.Role
 	// existingUser.IsVerified = true
 	// existingUser.VerifiedAt = time.Now()
 	return db.Save(&existingUser).Error
 }
 
-func All() []Seed {
+func All(hasher services.PasswordHasher) []Seed {
 	return []Seed{
 		{
 			Name: "CreateDefaultAdmin",
 			Run: func(db *gorm.DB) error {
 				return CreateUser(
 					db,
+					hasher,
 					"default",
 					"admin",
 					os.Getenv("DEFAULT_ADMIN_EMAIL"),
@@ -99,6 +113,7 @@ func All() []Seed {
 			Run: func(db *gorm.DB) error {
 				return CreateUser(
 					db,
+					hasher,
 					"default",
 					"user",
 					os.Getenv("DEFAULT_USER_EMAIL"),
@@ -0,0 +1,57 @@
+// Package dbtest opens throwaway databases for tests, so code written
+// against gorm can be exercised without a Postgres server.
+package dbtest
+
+import (
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+// New returns an empty in-memory SQLite database with every model migrated.
+// It is closed when the test ends.
+func New(t testing.TB) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a database of its own
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(domain.GetModels()...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Service wraps db as the DBService handlers are given.
+func Service(db *gorm.DB) domain.DBService {
+	return &service{db: db}
+}
+
+type service struct {
+	db *gorm.DB
+}
+
+func (s *service) Health() map[string]string {
+	return map[string]string{"message": "It's healthy"}
+}
+
+func (s *service) GetClient() *gorm.DB {
+	return s.db
+}
+
+func (s *service) AutoMigrateAll(models []interface{}) {
+	s.db.AutoMigrate(models...)
+}
@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+// AuditLog records an administrative change. ActorID is nil for changes
+// made by the system itself.
+type AuditLog struct {
+	ID         uint      `gorm:"primaryKey;autoIncrement:true;not null" json:"id"`
+	ActorID    *uint     `gorm:"index"                                  json:"actor_id"`
+	Action     string    `gorm:"index;not null"                         json:"action"`
+	TargetType string    `                                              json:"target_type"`
+	TargetID   string    `                                              json:"target_id"`
+	Details    string    `gorm:"type:text"                              json:"details,omitempty"`
+	IP         string    `                                              json:"ip"`
+	CreatedAt  time.Time `gorm:"index"                                  json:"created_at"`
+}
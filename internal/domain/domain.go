@@ -4,5 +4,11 @@ func GetModels() []interface{} {
 	return []interface{}{
 		&User{},
 		&Token{},
+		&SAMLConnection{},
+		&PasswordHistory{},
+		&RateLimitBucket{},
+		&LoginAttempt{},
+		&NetworkRule{},
+		&AuditLog{},
 	}
 }
@@ -1,20 +1,93 @@
 package domain
 
+import "time"
+
 type Env struct {
-	PORT                       int    `envconfig:"PORT"                          required:"true"`
-	APP_ENV                    string `envconfig:"APP_ENV"                       required:"true"`
-	DB_HOST                    string `envconfig:"DB_HOST"                       required:"true"`
-	DB_PORT                    string `envconfig:"DB_PORT"                       required:"true"`
-	DB_DATABASE                string `envconfig:"DB_DATABASE"                   required:"true"`
-	DB_USERNAME                string `envconfig:"DB_USERNAME"                   required:"true"`
-	DB_PASSWORD                string `envconfig:"DB_PASSWORD"                   required:"true"`
-	AccessTokenExpiryHour      uint   `envconfig:"ACCESS_TOKEN_EXPIRY_HOUR"      required:"true"`
-	RefreshTokenExpiryHour     uint   `envconfig:"REFRESH_TOKEN_EXPIRY_HOUR"     required:"true"`
-	AccessTokenSecret          string `envconfig:"ACCESS_TOKEN_SECRET"           required:"true"`
-	RefreshTokenSecret         string `envconfig:"REFRESH_TOKEN_SECRET"          required:"true"`
-	POSTMARK_API_KEY           string `envconfig:"POSTMARK_API_KEY"              required:"true"`
-	POSTMARK_FROM_EMAIL        string `envconfig:"POSTMARK_FROM_EMAIL"           required:"true"`
-	ConfirmCodeLength          uint   `envconfig:"CONFIRM_CODE_LENGTH"           required:"true"`
-	ConfirmationCodeExpiryHour uint   `envconfig:"CONFRIMATION_CODE_EXPIRY_HOUR" required:"true"`
-	Timezone                   string `envconfig:"TIMEZONE"                      required:"true"`
+	PORT                          int       `envconfig:"PORT"                              required:"true"`
+	APP_ENV                       string    `envconfig:"APP_ENV"                           required:"true"`
+	DB_HOST                       string    `envconfig:"DB_HOST"                           required:"true"`
+	DB_PORT                       string    `envconfig:"DB_PORT"                           required:"true"`
+	DB_DATABASE                   string    `envconfig:"DB_DATABASE"                       required:"true"`
+	DB_USERNAME                   string    `envconfig:"DB_USERNAME"                       required:"true"`
+	DB_PASSWORD                   string    `envconfig:"DB_PASSWORD"                       required:"true"`
+	AccessTokenExpiryHour         uint      `envconfig:"ACCESS_TOKEN_EXPIRY_HOUR"          required:"true"`
+	RefreshTokenExpiryHour        uint      `envconfig:"REFRESH_TOKEN_EXPIRY_HOUR"         required:"true"`
+	AccessTokenSecret             string    `envconfig:"ACCESS_TOKEN_SECRET"               required:"true"`
+	RefreshTokenSecret            string    `envconfig:"REFRESH_TOKEN_SECRET"              required:"true"`
+	SessionlessTokensUntil        time.Time `envconfig:"SESSIONLESS_TOKENS_UNTIL"`
+	POSTMARK_API_KEY              string    `envconfig:"POSTMARK_API_KEY"                  required:"true"`
+	POSTMARK_FROM_EMAIL           string    `envconfig:"POSTMARK_FROM_EMAIL"               required:"true"`
+	ConfirmCodeLength             uint      `envconfig:"CONFIRM_CODE_LENGTH"               required:"true"`
+	CodeMaxAttempts               int       `envconfig:"CODE_MAX_ATTEMPTS"                 default:"5"`
+	ConfirmationCodeExpiryHour    uint      `envconfig:"CONFRIMATION_CODE_EXPIRY_HOUR"     required:"true"`
+	Timezone                      string    `envconfig:"TIMEZONE"                          required:"true"`
+	AuthBackend                   string    `envconfig:"AUTH_BACKEND"                      default:"local"`
+	AdminAuthBackend              string    `envconfig:"ADMIN_AUTH_BACKEND"                default:"local"`
+	LDAPURL                       string    `envconfig:"LDAP_URL"`
+	LDAPStartTLS                  bool      `envconfig:"LDAP_START_TLS"`
+	LDAPInsecureSkipVerify        bool      `envconfig:"LDAP_INSECURE_SKIP_VERIFY"`
+	LDAPBindDN                    string    `envconfig:"LDAP_BIND_DN"`
+	LDAPBindPassword              string    `envconfig:"LDAP_BIND_PASSWORD"`
+	LDAPBaseDN                    string    `envconfig:"LDAP_BASE_DN"`
+	LDAPUserFilter                string    `envconfig:"LDAP_USER_FILTER"                  default:"(|(uid=%s)(mail=%s))"`
+	LDAPUsernameAttribute         string    `envconfig:"LDAP_USERNAME_ATTRIBUTE"           default:"uid"`
+	LDAPEmailAttribute            string    `envconfig:"LDAP_EMAIL_ATTRIBUTE"              default:"mail"`
+	LDAPGroupAttribute            string    `envconfig:"LDAP_GROUP_ATTRIBUTE"              default:"memberOf"`
+	LDAPGroupRoles                string    `envconfig:"LDAP_GROUP_ROLES"`
+	LDAPDefaultRole               string    `envconfig:"LDAP_DEFAULT_ROLE"`
+	PublicURL                     string    `envconfig:"PUBLIC_URL"                        default:"http://localhost:8080"`
+	SAMLCertFile                  string    `envconfig:"SAML_CERT_FILE"`
+	SAMLKeyFile                   string    `envconfig:"SAML_KEY_FILE"`
+	PasswordHashAlgorithm         string    `envconfig:"PASSWORD_HASH_ALGORITHM"           default:"argon2id"`
+	Argon2MemoryKiB               uint32    `envconfig:"ARGON2_MEMORY_KIB"                 default:"65536"`
+	Argon2Iterations              uint32    `envconfig:"ARGON2_ITERATIONS"                 default:"3"`
+	Argon2Parallelism             uint8     `envconfig:"ARGON2_PARALLELISM"                default:"2"`
+	BcryptCost                    int       `envconfig:"BCRYPT_COST"                       default:"12"`
+	PasswordPepperKeys            string    `envconfig:"PASSWORD_PEPPER_KEYS"`
+	PasswordPepperKeyID           string    `envconfig:"PASSWORD_PEPPER_KEY_ID"`
+	PasswordMaxAgeDays            uint      `envconfig:"PASSWORD_MAX_AGE_DAYS"             default:"0"`
+	PasswordChangeTokenMinutes    uint      `envconfig:"PASSWORD_CHANGE_TOKEN_MINUTES"     default:"10"`
+	PasswordHistorySize           int       `envconfig:"PASSWORD_HISTORY_SIZE"             default:"5"`
+	PasswordMinLength             int       `envconfig:"PASSWORD_MIN_LENGTH"               default:"8"`
+	PasswordMaxLength             int       `envconfig:"PASSWORD_MAX_LENGTH"               default:"72"`
+	PasswordRequireUpper          bool      `envconfig:"PASSWORD_REQUIRE_UPPER"`
+	PasswordRequireLower          bool      `envconfig:"PASSWORD_REQUIRE_LOWER"`
+	PasswordRequireDigit          bool      `envconfig:"PASSWORD_REQUIRE_DIGIT"`
+	PasswordRequireSymbol         bool      `envconfig:"PASSWORD_REQUIRE_SYMBOL"`
+	PasswordDisallowIdentity      bool      `envconfig:"PASSWORD_DISALLOW_IDENTITY"        default:"true"`
+	BreachedPasswordsFile         string    `envconfig:"BREACHED_PASSWORDS_FILE"`
+	TokenExchangeAudiences        []string  `envconfig:"TOKEN_EXCHANGE_AUDIENCES"`
+	TokenExchangeDefaultScopes    []string  `envconfig:"TOKEN_EXCHANGE_DEFAULT_SCOPES"`
+	RateLimitStore                string    `envconfig:"RATE_LIMIT_STORE"                  default:"memory"`
+	RateLimitIPBurst              uint      `envconfig:"RATE_LIMIT_IP_BURST"               default:"20"`
+	RateLimitIPWindowMinutes      uint      `envconfig:"RATE_LIMIT_IP_WINDOW_MINUTES"      default:"1"`
+	RateLimitAccountBurst         uint      `envconfig:"RATE_LIMIT_ACCOUNT_BURST"          default:"5"`
+	RateLimitAccountWindowMinutes uint      `envconfig:"RATE_LIMIT_ACCOUNT_WINDOW_MINUTES" default:"15"`
+	RateLimitRouteBurst           uint      `envconfig:"RATE_LIMIT_ROUTE_BURST"            default:"0"`
+	RateLimitRouteWindowMinutes   uint      `envconfig:"RATE_LIMIT_ROUTE_WINDOW_MINUTES"   default:"1"`
+	LockoutThreshold              uint      `envconfig:"LOCKOUT_THRESHOLD"                 default:"5"`
+	LockoutBaseMinutes            uint      `envconfig:"LOCKOUT_BASE_MINUTES"              default:"1"`
+	LockoutMaxMinutes             uint      `envconfig:"LOCKOUT_MAX_MINUTES"               default:"1440"`
+	LoginHistoryRetentionDays     uint      `envconfig:"LOGIN_HISTORY_RETENTION_DAYS"      default:"90"`
+	ChallengeProvider             string    `envconfig:"CHALLENGE_PROVIDER"                default:"pow"`
+	ChallengeSiteKey              string    `envconfig:"CHALLENGE_SITE_KEY"`
+	ChallengeSecret               string    `envconfig:"CHALLENGE_SECRET"`
+	PowSecret                     string    `envconfig:"POW_SECRET"`
+	PowDifficulty                 int       `envconfig:"POW_DIFFICULTY"                    default:"20"`
+	ChallengeIPBurst              uint      `envconfig:"CHALLENGE_IP_BURST"                default:"10"`
+	ChallengeIPWindowMinutes      uint      `envconfig:"CHALLENGE_IP_WINDOW_MINUTES"       default:"10"`
+	ChallengeFailureBurst         uint      `envconfig:"CHALLENGE_FAILURE_BURST"           default:"3"`
+	ChallengeFailureWindowMinutes uint      `envconfig:"CHALLENGE_FAILURE_WINDOW_MINUTES"  default:"15"`
+	TrustedProxies                []string  `envconfig:"TRUSTED_PROXIES"`
+	TrustedPlatform               string    `envconfig:"TRUSTED_PLATFORM"`
+	NetworkAllow                  []string  `envconfig:"NETWORK_ALLOW"`
+	NetworkDeny                   []string  `envconfig:"NETWORK_DENY"`
+	AdminNetworkAllow             []string  `envconfig:"ADMIN_NETWORK_ALLOW"`
+	PrivacyMode                   bool      `envconfig:"PRIVACY_MODE"`
+	InviteURL                     string    `envconfig:"INVITE_URL"                        default:"http://localhost:3000/invite"`
+	InviteExpiryHour              uint      `envconfig:"INVITE_EXPIRY_HOUR"                default:"72"`
+	UserRetentionDays             uint      `envconfig:"USER_RETENTION_DAYS"               default:"0"`
+	AccountDeletionGraceDays      uint      `envconfig:"ACCOUNT_DELETION_GRACE_DAYS"       default:"14"`
+	AccountDeletionMode           string    `envconfig:"ACCOUNT_DELETION_MODE"             default:"purge"`
+	AccountDeletionCancelURL      string    `envconfig:"ACCOUNT_DELETION_CANCEL_URL"       default:"http://localhost:3000/cancel-deletion"`
 }
@@ -0,0 +1,31 @@
+package domain
+
+import "time"
+
+type LoginOutcome string
+
+const (
+	LoginSucceeded          LoginOutcome = "success"
+	LoginInvalidCredentials LoginOutcome = "invalid_credentials"
+	LoginLocked             LoginOutcome = "locked"
+	LoginPasswordExpired    LoginOutcome = "password_change_required"
+	LoginNetworkDenied      LoginOutcome = "network_denied"
+	LoginSuspended          LoginOutcome = "suspended"
+	LoginDeletionScheduled  LoginOutcome = "deletion_scheduled"
+	LoginFailed             LoginOutcome = "error"
+)
+
+// LoginAttempt records one sign-in attempt. UserID is nil and Identifier
+// empty when the identifier did not match an account.
+type LoginAttempt struct {
+	ID         uint         `gorm:"primaryKey;autoIncrement:true;not null" json:"id"`
+	UserID     *uint        `gorm:"index"                                  json:"-"`
+	User       *User        `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Identifier string       `                                              json:"-"`
+	Method     string       `                                              json:"method"`
+	Outcome    LoginOutcome `                                              json:"outcome"`
+	IP         string       `                                              json:"ip"`
+	IPRange    string       `gorm:"index"                                  json:"-"`
+	UserAgent  string       `                                              json:"user_agent"`
+	CreatedAt  time.Time    `gorm:"index"                                  json:"created_at"`
+}
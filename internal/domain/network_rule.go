@@ -0,0 +1,24 @@
+package domain
+
+import "time"
+
+type NetworkAction string
+
+const (
+	NetworkAllow NetworkAction = "allow"
+	NetworkDeny  NetworkAction = "deny"
+)
+
+// NetworkRule allows or denies a CIDR range. Rules without a Role apply to
+// every request, the rest only to users with that role. Once any allow
+// rule exists for a scope, addresses outside every allow rule of that scope
+// are refused.
+type NetworkRule struct {
+	ID        uint          `gorm:"primaryKey;autoIncrement:true;not null" json:"id"`
+	CIDR      string        `gorm:"not null"                               json:"cidr"`
+	Action    NetworkAction `gorm:"not null"                               json:"action"`
+	Role      Role          `gorm:"index"                                  json:"role,omitempty"`
+	Note      string        `                                              json:"note"`
+	CreatedAt time.Time     `                                              json:"created_at"`
+	UpdatedAt time.Time     `                                              json:"updated_at,omitempty"`
+}
@@ -0,0 +1,13 @@
+package domain
+
+import "time"
+
+// PasswordHistory keeps the hashes of a user's recent passwords so they can
+// not be reused.
+type PasswordHistory struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement:true;not null" json:"id"`
+	UserID    uint      `gorm:"index"                                  json:"user_id"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Hash      string    `gorm:"not null"                               json:"-"`
+	CreatedAt time.Time `                                              json:"created_at"`
+}
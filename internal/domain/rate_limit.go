@@ -0,0 +1,11 @@
+package domain
+
+import "time"
+
+// RateLimitBucket is a token bucket shared between instances.
+type RateLimitBucket struct {
+	Key       string    `gorm:"primaryKey"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime:false"`
+	ExpiresAt time.Time `gorm:"index"`
+}
@@ -6,6 +6,10 @@ const (
 	AdminRole Role = "admin"
 	UserRole  Role = "user"
 	GuestRole Role = "guest"
+	// ServiceRole marks service principals, accounts that belong to another
+	// service rather than a person. They authenticate to the token exchange
+	// endpoint as clients.
+	ServiceRole Role = "service"
 )
 
 // DefineRoles defines the roles that users can have
@@ -13,7 +17,8 @@ type DefineRoles map[Role][]string
 
 // Roles defines the roles map
 var Roles = DefineRoles{
-	AdminRole: {"admin", "user", "guest"},
-	UserRole:  {"user", "guest"},
-	GuestRole: {"guest"},
+	AdminRole:   {"admin", "user", "guest"},
+	UserRole:    {"user", "guest"},
+	GuestRole:   {"guest"},
+	ServiceRole: {"service"},
 }
@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SAMLConnection configures this service as a SAML service provider for a
+// single customer IdP. Attribute fields name the assertion attributes that
+// populate the matching User fields, and EmailDomains limits which accounts
+// the IdP may sign in.
+type SAMLConnection struct {
+	ID                 uint           `gorm:"primaryKey;autoIncrement:true;not null" json:"id"`
+	Slug               string         `gorm:"unique;not null"                        json:"slug"`
+	Name               string         `                                              json:"name"`
+	IDPMetadataXML     string         `gorm:"type:text;not null"                     json:"idp_metadata_xml"`
+	IDPCertificate     string         `gorm:"type:text"                              json:"idp_certificate"`
+	EmailDomains       string         `                                              json:"email_domains"`
+	EmailAttribute     string         `gorm:"default:email"                          json:"email_attribute"`
+	UsernameAttribute  string         `                                              json:"username_attribute"`
+	FirstnameAttribute string         `                                              json:"firstname_attribute"`
+	LastnameAttribute  string         `                                              json:"lastname_attribute"`
+	RoleAttribute      string         `                                              json:"role_attribute"`
+	DefaultRole        Role           `gorm:"default:user"                           json:"default_role"`
+	AllowIDPInitiated  bool           `                                              json:"allow_idp_initiated"`
+	Enabled            bool           `gorm:"default:true"                           json:"enabled"`
+	CreatedAt          time.Time      `                                              json:"created_at"`
+	UpdatedAt          time.Time      `                                              json:"updated_at,omitempty"`
+	DeletedAt          gorm.DeletedAt `gorm:"index"                                  json:"-"`
+}
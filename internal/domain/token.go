@@ -9,11 +9,14 @@ import (
 type TokenType string
 
 const (
-	ACCESS         TokenType = "access"
-	REFRESH        TokenType = "refresh"
-	RESET_PASSWORD TokenType = "reset_password"
-	VERIFY_EMAIL   TokenType = "verify_email"
-	VERIFY_PHONE   TokenType = "verify_phone"
+	ACCESS          TokenType = "access"
+	REFRESH         TokenType = "refresh"
+	RESET_PASSWORD  TokenType = "reset_password"
+	VERIFY_EMAIL    TokenType = "verify_email"
+	VERIFY_PHONE    TokenType = "verify_phone"
+	UNLOCK_ACCOUNT  TokenType = "unlock_account"
+	INVITE          TokenType = "invite"
+	CANCEL_DELETION TokenType = "cancel_deletion"
 )
 
 type Token struct {
@@ -23,6 +26,7 @@ type Token struct {
 	Type      TokenType
 	Hash      string         `gorm:"unique"`
 	ExpiresAt time.Time      `                                              `
+	Attempts  int            `                                              json:"-"`
 	CreatedAt time.Time      `                                              json:"created_at"`
 	UpdatedAt time.Time      `                                              json:"updated_at,omitempty"`
 	DeletedAt gorm.DeletedAt `gorm:"index"                                  json:"-"`
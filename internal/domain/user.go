@@ -7,19 +7,42 @@ import (
 )
 
 type User struct {
-	ID              uint           `gorm:"primaryKey;autoIncrement:true;not null" json:"id"`
-	Username        string         `gorm:"unique;not null"                        json:"username"`
-	Email           string         `gorm:"unique;not null"                        json:"email"`
-	Password        string         `gorm:"not null"                               json:"-"`
-	Phone           *string        `gorm:"unique;"                                json:"phone"`
-	Firstname       string         `                                              json:"first_name"`
-	Lastname        string         `                                              json:"last_name"`
-	AvatarURL       string         `                                              json:"avatar_url"`
-	Role            Role           `                                              json:"role"`
-	IsEmailVerified bool           `                                              json:"is_email_verified"`
-	LastLoggedInAt  time.Time      `                                              json:"last_logged_in_at"`
-	VerifiedAt      time.Time      `                                              json:"verified_at"`
-	CreatedAt       time.Time      `                                              json:"created_at"`
-	UpdatedAt       time.Time      `                                              json:"updated_at,omitempty"`
-	DeletedAt       gorm.DeletedAt `gorm:"index"                                  json:"-"`
+	ID                  uint           `gorm:"primaryKey;autoIncrement:true;not null" json:"id"`
+	Username            string         `gorm:"unique;not null"                        json:"username"`
+	Email               string         `gorm:"unique;not null"                        json:"email"`
+	Password            string         `gorm:"not null"                               json:"-"`
+	Phone               *string        `gorm:"unique;"                                json:"phone"`
+	Firstname           string         `                                              json:"first_name"`
+	Lastname            string         `                                              json:"last_name"`
+	AvatarURL           string         `                                              json:"avatar_url"`
+	Role                Role           `                                              json:"role"`
+	AuthProvider        string         `gorm:"default:local"                          json:"auth_provider"`
+	ProviderRole        Role           `                                              json:"provider_role,omitempty"`
+	SAMLConnectionID    *uint          `                                              json:"saml_connection_id"`
+	IsEmailVerified     bool           `                                              json:"is_email_verified"`
+	LastLoggedInAt      time.Time      `                                              json:"last_logged_in_at"`
+	PasswordChangedAt   *time.Time     `                                              json:"password_changed_at"`
+	MustChangePassword  bool           `                                              json:"must_change_password"`
+	FailedLoginAttempts int            `                                              json:"failed_login_attempts"`
+	LastFailedLoginAt   *time.Time     `                                              json:"last_failed_login_at"`
+	LockedUntil         *time.Time     `                                              json:"locked_until"`
+	LockoutCount        int            `                                              json:"lockout_count"`
+	SuspendedAt         *time.Time     `                                              json:"suspended_at"`
+	SuspendedUntil      *time.Time     `                                              json:"suspended_until"`
+	SuspensionReason    string         `                                              json:"suspension_reason"`
+	SuspendedByID       *uint          `                                              json:"suspended_by_id"`
+	DeletionScheduledAt *time.Time     `                                              json:"deletion_scheduled_at"`
+	AnonymizedAt        *time.Time     `                                              json:"anonymized_at"`
+	InvitedAt           *time.Time     `                                              json:"invited_at"`
+	InvitedByID         *uint          `                                              json:"invited_by_id"`
+	InviteAcceptedAt    *time.Time     `                                              json:"invite_accepted_at"`
+	VerifiedAt          time.Time      `                                              json:"verified_at"`
+	CreatedAt           time.Time      `                                              json:"created_at"`
+	UpdatedAt           time.Time      `                                              json:"updated_at,omitempty"`
+	DeletedAt           gorm.DeletedAt `gorm:"index"                                  json:"-"`
 }
+
+// UserSearchExpression is what free text user search matches against. The
+// trigram index created at migration is built on exactly this expression.
+const UserSearchExpression = "(username || ' ' || email || ' ' || " +
+	"coalesce(firstname, '') || ' ' || coalesce(lastname, ''))"
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+// reauthWindow is how recent a single sign-on counts as re-authentication
+// for users who have no password here.
+const reauthWindow = 10 * time.Minute
+
+// DeleteAccount schedules the signed in user's account for deletion after
+// the grace period and signs them out everywhere. The emailed link cancels
+// it until then.
+func (s *AuthHandler) DeleteAccount(c *gin.Context) {
+	var details struct {
+		Password string `json:"password"`
+	}
+	if c.ShouldBind(&details) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+		return
+	}
+	payload, err := util.GetPayload(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrFailParsePayload})
+		return
+	}
+	user := &domain.User{}
+	if err := s.Db.GetClient().First(user, payload.ID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": helper.ErrUnauthorized})
+		return
+	}
+	if !s.reauthenticate(c, user, details.Password) {
+		return
+	}
+
+	deleteAt := time.Now().AddDate(0, 0, int(s.Env.AccountDeletionGraceDays))
+	err = s.Db.GetClient().Transaction(func(tx *gorm.DB) error {
+		if err := s.admins.Check(tx, user.ID); err != nil {
+			return err
+		}
+		return tx.Model(user).Update("deletion_scheduled_at", deleteAt).Error
+	})
+	if errors.Is(err, services.ErrLastAdmin) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrLastAdminDeletion})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	if err := s.ts.RevokeUserTokens(user.ID, domain.REFRESH, 0); err != nil {
+		s.L.Print(err)
+	}
+	code, err := s.ts.IssueCode(domain.CANCEL_DELETION, user.ID, linkCodeLength, deleteAt)
+	if err != nil {
+		s.L.Print(err)
+	} else {
+		link := fmt.Sprintf(
+			"%s?email=%s&code=%s",
+			s.Env.AccountDeletionCancelURL,
+			url.QueryEscape(user.Email),
+			url.QueryEscape(code),
+		)
+		s.sendAccountMail(
+			user.Email,
+			"Your account will be deleted",
+			fmt.Sprintf(
+				"The account %s will be deleted on %s. If you didn't ask for this, or changed your mind, "+
+					"cancel the deletion here: %s",
+				user.Username, deleteAt.UTC().Format(time.RFC1123), link,
+			),
+		)
+	}
+	c.JSON(http.StatusAccepted, domain.Response{
+		Message: helper.DeletionScheduled,
+		Data:    gin.H{"deletion_scheduled_at": deleteAt},
+	})
+}
+
+// CancelDeletion keeps an account scheduled for deletion. The owner signs
+// in again afterwards, their sessions are gone.
+func (s *AuthHandler) CancelDeletion(c *gin.Context) {
+	var details struct {
+		Email string `json:"email"`
+		Code  string `json:"code"`
+	}
+	if c.ShouldBind(&details) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+		return
+	}
+	user, ok := s.consumeCode(c, domain.CANCEL_DELETION, details.Email, details.Code)
+	if !ok {
+		return
+	}
+	if err := s.Db.GetClient().Model(user).Update("deletion_scheduled_at", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	s.sendAccountMail(
+		user.Email,
+		"Account deletion cancelled",
+		fmt.Sprintf("The account %s will not be deleted, you can sign in again.", user.Username),
+	)
+	c.JSON(http.StatusOK, domain.Response{Message: helper.Success})
+}
+
+// reauthenticate checks that the user in front of the screen is the owner
+// of the token: by password, counted towards a lockout like a sign-in, or
+// for single sign-on users by a recent sign-in through their identity
+// provider.
+func (s *AuthHandler) reauthenticate(c *gin.Context, user *domain.User, password string) bool {
+	switch user.AuthProvider {
+	case services.SAMLProvider:
+		attempts, _, err := s.logins.List(user.ID, nil, 1)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+			return false
+		}
+		if len(attempts) == 0 ||
+			attempts[0].Outcome != domain.LoginSucceeded ||
+			attempts[0].Method != services.SAMLProvider ||
+			time.Since(attempts[0].CreatedAt) > reauthWindow {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": helper.ErrReauthRequired})
+			return false
+		}
+		return true
+	default:
+		return s.checkPassword(c, user, password)
+	}
+}
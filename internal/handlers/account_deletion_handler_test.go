@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func TestDeleteAccountLockout(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "ada", domain.UserRole, "correct horse")
+	deleteAccount := func(password string) int {
+		return serve(ts.DeleteAccount, http.MethodDelete, "/users/me", gin.H{"password": password}, payloadFor(user)).Code
+	}
+	for i := 0; i < int(ts.Env.LockoutThreshold); i++ {
+		if code := deleteAccount("wrong horse"); code != http.StatusUnauthorized {
+			t.Fatalf("wrong password %d = %d, want 401", i+1, code)
+		}
+	}
+	if code := deleteAccount("correct horse"); code != http.StatusForbidden {
+		t.Errorf("deleting after too many wrong passwords = %d, want 403", code)
+	}
+	stored := &domain.User{}
+	ts.db.First(stored, user.ID)
+	if stored.DeletionScheduledAt != nil {
+		t.Error("locked account was scheduled for deletion")
+	}
+}
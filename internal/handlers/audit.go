@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+// audit records a change made through request c. The change has already
+// happened, so failures are only logged.
+func audit(
+	c *gin.Context,
+	auditService services.AuditService,
+	l *log.Logger,
+	action, targetType string,
+	targetID uint,
+	details interface{},
+) {
+	entry := domain.AuditLog{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   strconv.FormatUint(uint64(targetID), 10),
+		IP:         c.ClientIP(),
+	}
+	if payload, err := util.GetPayload(c); err == nil {
+		entry.ActorID = &payload.ID
+	}
+	if err := auditService.Record(entry, details); err != nil {
+		l.Print(err)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+const (
+	defaultAuditLogLimit = 100
+	maxAuditLogLimit     = 1000
+)
+
+type AuditHandler struct {
+	*domain.Server
+	audit services.AuditService
+}
+
+func NewAuditHandler(s *domain.Server, audit services.AuditService) *AuditHandler {
+	return &AuditHandler{Server: s, audit: audit}
+}
+
+// GetAuditLogs lists audit log entries newest first. Older entries are
+// reached by following the cursors in the page meta.
+func (s *AuditHandler) GetAuditLogs(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAuditLogLimit)))
+	if err != nil || limit <= 0 || limit > maxAuditLogLimit {
+		limit = defaultAuditLogLimit
+	}
+	cursor, err := util.GetCursorParam(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entries, meta, err := s.audit.List(cursor, limit)
+	if errors.Is(err, util.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailGet("audit logs")})
+		return
+	}
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    &entries,
+		Meta:    meta,
+	})
+}
@@ -4,10 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
 	"github.com/ostheperson/go-auth-service/integrations"
@@ -18,22 +18,54 @@ import (
 )
 
 type LoginResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken            string `json:"access_token"`
+	RefreshToken           string `json:"refresh_token,omitempty"`
+	PasswordChangeRequired bool   `json:"password_change_required,omitempty"`
 }
 
 type AuthHandler struct {
 	*domain.Server
-	mailer integrations.MailerService
-	ts     services.TokenService
+	mailer        integrations.MailerService
+	ts            services.TokenService
+	verifier      services.CredentialVerifier
+	adminVerifier services.CredentialVerifier
+	policy        services.PasswordPolicy
+	hasher        services.PasswordHasher
+	history       services.PasswordHistoryService
+	lockout       services.LockoutService
+	logins        services.LoginHistoryService
+	network       services.NetworkPolicy
+	admins        services.AdminGuard
 }
 
 func NewAuthHandler(
 	s *domain.Server,
 	mailer integrations.MailerService,
 	tokenService services.TokenService,
+	verifier services.CredentialVerifier,
+	adminVerifier services.CredentialVerifier,
+	policy services.PasswordPolicy,
+	hasher services.PasswordHasher,
+	history services.PasswordHistoryService,
+	lockout services.LockoutService,
+	logins services.LoginHistoryService,
+	network services.NetworkPolicy,
+	admins services.AdminGuard,
 ) *AuthHandler {
-	return &AuthHandler{s, mailer, tokenService}
+	return &AuthHandler{
+		s,
+		mailer,
+		tokenService,
+		verifier,
+		adminVerifier,
+		policy,
+		hasher,
+		history,
+		lockout,
+		logins,
+		network,
+		admins,
+	}
 }
 
 func (s *AuthHandler) SignUp(c *gin.Context) {
@@ -49,19 +81,21 @@ func (s *AuthHandler) SignUp(c *gin.Context) {
 		})
 		return
 	}
-	user := domain.User{}
-	if err := s.Db.GetClient().Where("email = ? OR username = ?", newUser.Email, newUser.Username).First(&user).Error; err != gorm.ErrRecordNotFound {
-		if user.Username == newUser.Username {
-			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrExistingUsername})
-			return
-		}
-		if user.Email == newUser.Email {
-			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrExistingEmail})
-			return
-		}
+	user := domain.User{
+		Email:    newUser.Email,
+		Role:     domain.UserRole,
+		Username: newUser.Username,
+	}
+	if violations := s.policy.Validate(newUser.Password, &user); len(violations) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      helper.ErrWeakPassword,
+			"violations": violations,
+		})
+		return
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), 10)
+	// hash before looking for conflicts so both outcomes cost the same
+	hash, err := s.hasher.Hash(newUser.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": helper.ErrFailHash,
@@ -69,12 +103,33 @@ func (s *AuthHandler) SignUp(c *gin.Context) {
 		return
 	}
 
-	user = domain.User{
-		Email:    newUser.Email,
-		Password: string(hash),
-		Role:     domain.UserRole,
-		Username: newUser.Username,
+	var existing []domain.User
+	err = s.Db.GetClient().
+		Where("email = ? OR username = ?", newUser.Email, newUser.Username).
+		Find(&existing).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	if len(existing) > 0 {
+		if s.Env.PrivacyMode {
+			s.reportSignUpConflict(existing, newUser.Email, newUser.Username)
+			c.JSON(http.StatusCreated, domain.Response{Message: helper.SignUpAccepted})
+			return
+		}
+		for _, other := range existing {
+			if other.Username == newUser.Username {
+				c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrExistingUsername})
+				return
+			}
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrExistingEmail})
+		return
 	}
+
+	now := time.Now()
+	user.Password = hash
+	user.PasswordChangedAt = &now
 	result := s.Db.GetClient().Create(&user)
 
 	if result.Error != nil {
@@ -83,8 +138,20 @@ func (s *AuthHandler) SignUp(c *gin.Context) {
 		})
 		return
 	}
+	if err := s.history.Record(user.ID, user.Password); err != nil {
+		s.L.Print(err)
+	}
 
 	// Respond
+	if s.Env.PrivacyMode {
+		s.sendAccountMail(
+			user.Email,
+			"Welcome",
+			fmt.Sprintf("Your account %s has been created, you can now sign in.", user.Username),
+		)
+		c.JSON(http.StatusCreated, domain.Response{Message: helper.SignUpAccepted})
+		return
+	}
 	c.JSON(http.StatusCreated, domain.Response{
 		Message: helper.Success,
 		Data:    &user,
@@ -92,6 +159,19 @@ func (s *AuthHandler) SignUp(c *gin.Context) {
 }
 
 func (s *AuthHandler) SignIn(c *gin.Context) {
+	s.signIn(c, s.verifier, s.Env.AuthBackend)
+}
+
+func (s *AuthHandler) SignInAdmin(c *gin.Context) {
+	s.signIn(c, s.adminVerifier, s.Env.AdminAuthBackend, domain.AdminRole)
+}
+
+func (s *AuthHandler) signIn(
+	c *gin.Context,
+	verifier services.CredentialVerifier,
+	method string,
+	roles ...domain.Role,
+) {
 	var details struct {
 		Email    string
 		Username string
@@ -103,76 +183,72 @@ func (s *AuthHandler) SignIn(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	user := domain.User{}
-	if err := s.Db.GetClient().Where("email = ? OR username = ?", details.Email, details.Username).First(&user).Error; err != nil {
-		if err != gorm.ErrRecordNotFound {
-			if user.Username == details.Username {
-				c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrNoExistingUsername})
-				return
-			}
-			if user.Email == details.Email {
-				c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrNoExistingEmail})
-				return
-			}
-		}
+	identifier := details.Email
+	if identifier == "" {
+		identifier = details.Username
+	}
+	outcome := domain.LoginFailed
+	var user *domain.User
+	defer func() {
+		s.recordLogin(c, identifier, user, method, outcome)
+	}()
+
+	// locked accounts look exactly like a wrong password, so lockouts
+	// don't reveal which accounts exist
+	locked, err := s.lockout.Locked(identifier)
+	if err != nil {
+		s.L.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
 	}
-	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(details.Password)) != nil {
+	if locked {
+		outcome = domain.LoginLocked
+		s.hasher.Dummy(details.Password)
+		util.MarkCredentialFailure(c)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": helper.ErrInvalidCredentials})
 		return
 	}
-	accessToken, refreshToken, err := login(&user, s)
+	user, err = verifier.Verify(identifier, details.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if errors.Is(err, services.ErrLastAdmin) {
+			c.JSON(http.StatusForbidden, gin.H{"error": helper.ErrLastAdminProvider})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			outcome = domain.LoginInvalidCredentials
+			s.failSignIn(identifier)
+			util.MarkCredentialFailure(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": helper.ErrInvalidCredentials})
+			return
+		}
+		s.L.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
 		return
 	}
-
-	loginResponse := LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+	if len(roles) > 0 && !slices.Contains(roles, user.Role) {
+		outcome = domain.LoginInvalidCredentials
+		util.MarkCredentialFailure(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": helper.ErrInvalidCredentials})
+		return
 	}
-
-	c.JSON(http.StatusOK, domain.Response{
-		Message: helper.Success,
-		Data:    loginResponse,
-	})
-}
-
-func (s *AuthHandler) SignInAdmin(c *gin.Context) {
-	var details struct {
-		Email    string
-		Username string
-		Password string
+	if err := s.lockout.Succeed(user); err != nil {
+		s.L.Print(err)
 	}
-
-	err := c.ShouldBind(&details)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if refused, ok := s.admit(c, user); !ok {
+		outcome = refused
 		return
 	}
-	user := domain.User{}
-	if err := s.Db.GetClient().
-		Where("email = ? OR username = ? AND role = ?", details.Email, details.Username, domain.AdminRole).
-		First(&user).Error; err != nil {
-		if err != gorm.ErrRecordNotFound {
-			if user.Username == details.Username {
-				c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrNoExistingUsername})
-				return
-			}
-			if user.Email == details.Email {
-				c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrNoExistingEmail})
-				return
-			}
-		}
-	}
-	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(details.Password)) != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": helper.ErrInvalidCredentials})
+	if s.passwordChangeRequired(user) {
+		outcome = domain.LoginPasswordExpired
+		s.issuePasswordChangeToken(c, user)
 		return
 	}
-	accessToken, refreshToken, err := login(&user, s)
+	accessToken, refreshToken, err := login(user, s)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	outcome = domain.LoginSucceeded
 
 	loginResponse := LoginResponse{
 		AccessToken:  accessToken,
@@ -185,6 +261,26 @@ func (s *AuthHandler) SignInAdmin(c *gin.Context) {
 	})
 }
 
+// admit refuses a sign-in by a user whose identity is already established
+// but who is suspended, scheduled for deletion or on a network their role
+// may not use. When it refuses it responds and returns the outcome to
+// record.
+func (s *AuthHandler) admit(c *gin.Context, user *domain.User) (domain.LoginOutcome, bool) {
+	if services.IsSuspended(user, time.Now()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": helper.ErrAccountSuspended})
+		return domain.LoginSuspended, false
+	}
+	if user.DeletionScheduledAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": helper.ErrDeletionScheduled})
+		return domain.LoginDeletionScheduled, false
+	}
+	if !s.network.Allowed(c.ClientIP(), user.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": helper.ErrNetworkNotAllowed})
+		return domain.LoginNetworkDenied, false
+	}
+	return "", true
+}
+
 func (s *AuthHandler) ForgotPassword(c *gin.Context) {
 	var details struct {
 		Email string `json:"email"`
@@ -198,29 +294,16 @@ func (s *AuthHandler) ForgotPassword(c *gin.Context) {
 	user := domain.User{}
 	err = s.Db.GetClient().Where("email = ?", details.Email).First(&user).Error
 	if err != nil {
-		if err != gorm.ErrRecordNotFound {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+			return
+		}
+		if !s.Env.PrivacyMode {
 			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrNoExistingEmail})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-		return
 	}
-	code := util.GenerateCode(s.Env.ConfirmCodeLength)
-	expires := time.Now().Add(time.Duration(s.Env.ConfirmationCodeExpiryHour) * time.Hour)
-	_, err = s.ts.CreateToken(domain.RESET_PASSWORD, code, user.ID, expires)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrGenerateToken})
-		return
-	}
-
-	err = s.mailer.SendMail(
-		s.Env.POSTMARK_FROM_EMAIL,
-		details.Email,
-		"Password reset code",
-		fmt.Sprintf("your reset code is %s", code),
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrCannotSendMail})
+	if user.ID != 0 && !s.sendCode(c, domain.RESET_PASSWORD, &user, "Password reset code", "your reset code is %s") {
 		return
 	}
 
@@ -242,37 +325,16 @@ func (s *AuthHandler) ResetPassword(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	token := domain.Token{}
-	err = s.Db.GetClient().Preload("User").Where("hash = ?", details.Code).First(&token).Error
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidCode})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-		return
-	}
-
-	if time.Now().After(token.ExpiresAt) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrExpiredCode})
-		return
-	}
-
-	hash, err := bcrypt.GenerateFromPassword([]byte(details.Password), 10)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": helper.ErrFailHash,
+	// a password that would be refused mustn't use up the code
+	user, ok := s.consumeCodeIf(c, domain.RESET_PASSWORD, details.Email, details.Code,
+		func(user *domain.User) bool {
+			return s.validPassword(c, user, details.Password)
 		})
+	if !ok {
 		return
 	}
-	token.User.Password = string(hash)
 
-	if err := s.Db.GetClient().Save(&token.User).Error; err != nil {
-		c.JSON(500, gin.H{"error": helper.ErrInternalError})
-		return
-	}
-	if err := s.Db.GetClient().Delete(&token).Error; err != nil {
-		c.JSON(500, gin.H{"error": helper.ErrInternalError})
+	if !s.savePassword(c, user, details.Password) {
 		return
 	}
 	c.JSON(http.StatusOK, domain.Response{
@@ -292,33 +354,17 @@ func (s *AuthHandler) ResendVerifyEmail(c *gin.Context) {
 	user := domain.User{}
 	err := s.Db.GetClient().Where("email = ?", email).First(&user).Error
 	if err != nil {
-		if err != gorm.ErrRecordNotFound {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+			return
+		}
+		if !s.Env.PrivacyMode {
 			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrNoExistingEmail})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-		return
-	}
-	s.Db.GetClient().
-		Where("type = ? AND userid = ?", domain.RESET_PASSWORD, user.ID).
-		Delete(&domain.User{})
-	code := util.GenerateCode(s.Env.ConfirmCodeLength)
-	s.L.Print(code)
-	expires := time.Now().Add(time.Duration(s.Env.ConfirmationCodeExpiryHour) * time.Hour)
-	_, err = s.ts.CreateToken(domain.RESET_PASSWORD, code, user.ID, expires)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrGenerateToken})
-		return
 	}
-
-	err = s.mailer.SendMail(
-		s.Env.POSTMARK_FROM_EMAIL,
-		email,
-		"Password reset code",
-		fmt.Sprintf("your reset code is %s", code),
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrCannotSendMail})
+	if user.ID != 0 &&
+		!s.sendCode(c, domain.VERIFY_EMAIL, &user, "Email verification code", "your verification code is %s") {
 		return
 	}
 
@@ -339,28 +385,16 @@ func (s *AuthHandler) ConfirmEmail(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	token := domain.Token{}
-	err = s.Db.GetClient().Preload("User").Where("hash = ?", details.Code).First(&token).Error
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidCode})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+	user, ok := s.consumeCode(c, domain.VERIFY_EMAIL, details.Email, details.Code)
+	if !ok {
 		return
 	}
 
-	if time.Now().After(token.ExpiresAt) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrExpiredCode})
-		return
-	}
-
-	token.User.IsEmailVerified = true
-	if err := s.Db.GetClient().Save(&token.User).Error; err != nil {
-		c.JSON(500, gin.H{"error": helper.ErrInternalError})
-		return
-	}
-	if err := s.Db.GetClient().Delete(&token).Error; err != nil {
+	err = s.Db.GetClient().Model(user).Updates(map[string]interface{}{
+		"is_email_verified": true,
+		"verified_at":       time.Now(),
+	}).Error
+	if err != nil {
 		c.JSON(500, gin.H{"error": helper.ErrInternalError})
 		return
 	}
@@ -380,7 +414,13 @@ func (s *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 	token := domain.Token{}
-	err = s.Db.GetClient().Preload("User").Where("hash = ?", details.Hash).First(&token).Error
+	err = s.Db.GetClient().
+		Preload("User").
+		Where(
+			"hash = ? AND type = ? AND expires_at > ?",
+			details.Hash, domain.REFRESH, time.Now(),
+		).
+		First(&token).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidRefreshToken})
@@ -392,7 +432,23 @@ func (s *AuthHandler) RefreshToken(c *gin.Context) {
 		)
 		return
 	}
-	accessToken, refreshToken, err := login(&token.User, s)
+	if services.IsSuspended(&token.User, time.Now()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": helper.ErrAccountSuspended})
+		return
+	}
+	if token.User.DeletionScheduledAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": helper.ErrDeletionScheduled})
+		return
+	}
+	if s.passwordChangeRequired(&token.User) {
+		c.JSON(http.StatusForbidden, gin.H{"error": helper.ErrPasswordChangeRequired})
+		return
+	}
+	accessToken, refreshToken, err := rotate(&token, s)
+	if errors.Is(err, errRefreshTokenUsed) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidRefreshToken})
+		return
+	}
 	if err != nil {
 		c.JSON(
 			http.StatusInternalServerError,
@@ -413,14 +469,43 @@ func (s *AuthHandler) RefreshToken(c *gin.Context) {
 
 func login(user *domain.User, s *AuthHandler) (string, string, error) {
 	// TODO: Handle devices
-	accessToken, err := util.CreateAccessToken(
+	refreshToken, err := util.CreateRefreshToken(
+		user,
+		s.Env.RefreshTokenSecret,
+		s.Env.RefreshTokenExpiryHour,
+	)
+	if err != nil {
+		return "", "", err
+	}
+	expires := time.Now().Add(time.Duration(s.Env.RefreshTokenExpiryHour) * time.Hour)
+	session, err := s.ts.CreateToken(domain.REFRESH, refreshToken, user.ID, expires)
+	if err != nil {
+		return "", "", err
+	}
+	accessToken, err := util.CreateSessionAccessToken(
 		user,
+		session.ID,
 		s.Env.AccessTokenSecret,
 		s.Env.AccessTokenExpiryHour,
 	)
 	if err != nil {
 		return "", "", err
 	}
+	user.LastLoggedInAt = time.Now()
+	if err := s.Db.GetClient().Save(&user).Error; err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+var errRefreshTokenUsed = errors.New("refresh token was already used")
+
+// rotate replaces the refresh token of session with a new one, keeping the
+// session ID so access tokens issued with it stay valid. A refresh token can
+// only be rotated once, presenting it again returns errRefreshTokenUsed.
+func rotate(session *domain.Token, s *AuthHandler) (string, string, error) {
+	user := &session.User
 	refreshToken, err := util.CreateRefreshToken(
 		user,
 		s.Env.RefreshTokenSecret,
@@ -429,15 +514,93 @@ func login(user *domain.User, s *AuthHandler) (string, string, error) {
 	if err != nil {
 		return "", "", err
 	}
-	expires := time.Now().Add(time.Duration(s.Env.RefreshTokenExpiryHour) * time.Hour)
-	_, err = s.ts.CreateToken(domain.REFRESH, refreshToken, user.ID, expires)
+	now := time.Now()
+	expires := now.Add(time.Duration(s.Env.RefreshTokenExpiryHour) * time.Hour)
+	err = s.Db.GetClient().Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&domain.Token{}).
+			Where("id = ? AND hash = ?", session.ID, session.Hash).
+			Updates(map[string]interface{}{"hash": refreshToken, "expires_at": expires})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errRefreshTokenUsed
+		}
+		return tx.Model(user).Update("last_logged_in_at", now).Error
+	})
 	if err != nil {
 		return "", "", err
 	}
-	user.LastLoggedInAt = time.Now()
-	if err := s.Db.GetClient().Save(&user).Error; err != nil {
+	accessToken, err := util.CreateSessionAccessToken(
+		user,
+		session.ID,
+		s.Env.AccessTokenSecret,
+		s.Env.AccessTokenExpiryHour,
+	)
+	if err != nil {
 		return "", "", err
 	}
-
 	return accessToken, refreshToken, nil
 }
+
+func (s *AuthHandler) issueCode(ttype domain.TokenType, userID uint) (string, error) {
+	expires := time.Now().Add(time.Duration(s.Env.ConfirmationCodeExpiryHour) * time.Hour)
+	return s.ts.IssueCode(ttype, userID, s.Env.ConfirmCodeLength, expires)
+}
+
+// consumeCode spends the code of type ttype issued to the account with
+// email. Unknown emails get the same answer as wrong codes.
+func (s *AuthHandler) consumeCode(
+	c *gin.Context,
+	ttype domain.TokenType,
+	email, code string,
+) (*domain.User, bool) {
+	return s.consumeCodeIf(c, ttype, email, code, nil)
+}
+
+// consumeCodeIf is consumeCode for requests that can still be refused once
+// the code is known to be right. accept reports why on c when it refuses,
+// and the code is then left for another try.
+func (s *AuthHandler) consumeCodeIf(
+	c *gin.Context,
+	ttype domain.TokenType,
+	email, code string,
+	accept func(user *domain.User) bool,
+) (*domain.User, bool) {
+	user := &domain.User{}
+	err := s.Db.GetClient().Where("email = ?", email).First(user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidCode})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return nil, false
+	}
+	if accept != nil {
+		if err := s.ts.Check(ttype, user.ID, code); err != nil {
+			s.codeError(c, err)
+			return nil, false
+		}
+		if !accept(user) {
+			return nil, false
+		}
+	}
+	if _, err := s.ts.Consume(ttype, user.ID, code); err != nil {
+		s.codeError(c, err)
+		return nil, false
+	}
+	return user, true
+}
+
+func (s *AuthHandler) codeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidCode})
+	case errors.Is(err, services.ErrExpiredCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrExpiredCode})
+	default:
+		s.L.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+	}
+}
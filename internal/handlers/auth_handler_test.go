@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+func TestRefreshToken(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "ada", domain.UserRole, "correct horse")
+	accessToken, refreshToken, err := login(user, ts.AuthHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := util.VerifyAndExtract(accessToken, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := func(hash string) (int, LoginResponse) {
+		w := serve(ts.RefreshToken, http.MethodPost, "/auth/refresh", gin.H{"hash": hash}, nil)
+		var resp struct {
+			Data LoginResponse
+		}
+		if w.Code == http.StatusOK {
+			decode(t, w, &resp)
+		}
+		return w.Code, resp.Data
+	}
+	code, rotated := refresh(refreshToken)
+	if code != http.StatusOK || rotated.RefreshToken == "" || rotated.RefreshToken == refreshToken {
+		t.Fatalf("refresh = %d %+v, want a new refresh token", code, rotated)
+	}
+	rotatedClaims, err := util.VerifyAndExtract(rotated.AccessToken, testSecret)
+	if err != nil || rotatedClaims.SessionID != claims.SessionID {
+		t.Errorf("rotated access token = %+v, %v, want session %d", rotatedClaims, err, claims.SessionID)
+	}
+	if got := ts.sessions(t, user); !slices.Equal(got, []uint{claims.SessionID}) {
+		t.Errorf("sessions after refresh = %v, want only %d", got, claims.SessionID)
+	}
+
+	if code, _ := refresh(refreshToken); code != http.StatusBadRequest {
+		t.Errorf("reusing a rotated refresh token = %d, want 400", code)
+	}
+	if code, _ := refresh(rotated.RefreshToken); code != http.StatusOK {
+		t.Errorf("refresh with the rotated token = %d, want 200", code)
+	}
+
+	_, expired, err := login(user, ts.AuthHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.db.Model(&domain.Token{}).Where("hash = ?", expired).Update("expires_at", time.Now().Add(-time.Minute))
+	if code, _ := refresh(expired); code != http.StatusBadRequest {
+		t.Errorf("refresh with an expired token = %d, want 400", code)
+	}
+}
+
+func TestResetPasswordKeepsCodeForRefusedPassword(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "ada", domain.UserRole, "correct horse")
+	code, err := ts.issueCode(domain.RESET_PASSWORD, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset := func(password string) int {
+		return serve(ts.ResetPassword, http.MethodPost, "/auth/reset-password/confirm", gin.H{
+			"email":        user.Email,
+			"code":         code,
+			"new_password": password,
+		}, nil).Code
+	}
+	if got := reset("correct horse"); got != http.StatusBadRequest {
+		t.Errorf("reset to the current password = %d, want 400", got)
+	}
+	if got := reset("ada-is-me-ada"); got != http.StatusBadRequest {
+		t.Errorf("reset to a password containing the username = %d, want 400", got)
+	}
+	if got := reset("battery staple"); got != http.StatusOK {
+		t.Fatalf("reset after refused passwords = %d, want the code to still work", got)
+	}
+	if got := reset("another staple"); got != http.StatusBadRequest {
+		t.Errorf("second reset with the same code = %d, want 400", got)
+	}
+	stored := &domain.User{}
+	ts.db.First(stored, user.ID)
+	if ok, _, _ := ts.hasher.Verify("battery staple", stored.Password); !ok {
+		t.Error("new password doesn't verify")
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	ts := newTestServer(t)
+	admit := func(user *domain.User) (domain.LoginOutcome, int) {
+		var outcome domain.LoginOutcome
+		w := serve(func(c *gin.Context) {
+			var ok bool
+			if outcome, ok = ts.admit(c, user); ok {
+				c.Status(http.StatusOK)
+			}
+		}, http.MethodPost, "/", nil, nil)
+		return outcome, w.Code
+	}
+	user := ts.createUser(t, "ada", domain.UserRole, "correct horse")
+	if outcome, code := admit(user); code != http.StatusOK || outcome != "" {
+		t.Errorf("admit = %q %d, want the user let in", outcome, code)
+	}
+	now := time.Now()
+	user.SuspendedAt = &now
+	if outcome, code := admit(user); code != http.StatusForbidden || outcome != domain.LoginSuspended {
+		t.Errorf("admit of a suspended user = %q %d", outcome, code)
+	}
+	user.SuspendedAt = nil
+	user.DeletionScheduledAt = &now
+	if outcome, code := admit(user); code != http.StatusForbidden || outcome != domain.LoginDeletionScheduled {
+		t.Errorf("admit of a user scheduled for deletion = %q %d", outcome, code)
+	}
+}
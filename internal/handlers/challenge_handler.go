@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+)
+
+type ChallengeHandler struct {
+	*domain.Server
+	verifier services.ChallengeVerifier
+}
+
+func NewChallengeHandler(s *domain.Server, verifier services.ChallengeVerifier) *ChallengeHandler {
+	return &ChallengeHandler{Server: s, verifier: verifier}
+}
+
+// GetChallenge lets clients fetch a challenge up front instead of waiting
+// for a 428 response.
+func (s *ChallengeHandler) GetChallenge(c *gin.Context) {
+	if s.verifier == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": helper.ErrChallengesDisabled})
+		return
+	}
+	challenge, err := s.verifier.Issue()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    challenge,
+	})
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+// Error codes of RFC 6749 section 5.2 and RFC 8693 section 2.2.2.
+const (
+	oauthInvalidRequest       = "invalid_request"
+	oauthInvalidClient        = "invalid_client"
+	oauthInvalidScope         = "invalid_scope"
+	oauthInvalidTarget        = "invalid_target"
+	oauthUnsupportedGrantType = "unsupported_grant_type"
+	oauthServerError          = "server_error"
+)
+
+type TokenExchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+// ExchangeToken implements the RFC 8693 token exchange grant. A service
+// principal, authenticated as the client, trades a user's access token for a
+// token limited to a single audience and scope. The client is recorded as
+// the actor in the act claim. An actor token, when sent, must be the
+// client's own.
+func (s *AuthHandler) ExchangeToken(c *gin.Context) {
+	var details struct {
+		GrantType          string `form:"grant_type"           json:"grant_type"`
+		SubjectToken       string `form:"subject_token"        json:"subject_token"`
+		SubjectTokenType   string `form:"subject_token_type"   json:"subject_token_type"`
+		ActorToken         string `form:"actor_token"          json:"actor_token"`
+		ActorTokenType     string `form:"actor_token_type"     json:"actor_token_type"`
+		Audience           string `form:"audience"             json:"audience"`
+		Scope              string `form:"scope"                json:"scope"`
+		RequestedTokenType string `form:"requested_token_type" json:"requested_token_type"`
+		ClientID           string `form:"client_id"            json:"client_id"`
+		ClientSecret       string `form:"client_secret"        json:"client_secret"`
+	}
+
+	err := c.ShouldBind(&details)
+	if err != nil {
+		tokenError(c, http.StatusBadRequest, oauthInvalidRequest, err.Error())
+		return
+	}
+	client, ok := s.exchangeClient(c, details.ClientID, details.ClientSecret)
+	if !ok {
+		return
+	}
+	if details.GrantType != util.GrantTypeTokenExchange {
+		tokenError(c, http.StatusBadRequest, oauthUnsupportedGrantType, helper.ErrUnsupportedGrantType)
+		return
+	}
+	if !isSupportedTokenType(details.SubjectTokenType) ||
+		(details.RequestedTokenType != "" && !isSupportedTokenType(details.RequestedTokenType)) {
+		tokenError(c, http.StatusBadRequest, oauthInvalidRequest, helper.ErrUnsupportedTokenType)
+		return
+	}
+	if !s.isExchangeAudience(details.Audience) {
+		tokenError(c, http.StatusBadRequest, oauthInvalidTarget, helper.ErrInvalidAudience)
+		return
+	}
+
+	subject, err := util.VerifyAndExtract(details.SubjectToken, s.Env.AccessTokenSecret)
+	if err != nil || subject.Scope == util.ScopePasswordChange || subject.Role == domain.ServiceRole {
+		tokenError(c, http.StatusBadRequest, oauthInvalidRequest, helper.ErrInvalidSubjectToken)
+		return
+	}
+	// a token exchanged earlier may only be exchanged again by the service
+	// it was issued to, whose principal is named after its audience
+	if len(subject.Audience) > 0 && !slices.Contains(subject.Audience, client.Username) {
+		tokenError(c, http.StatusBadRequest, oauthInvalidRequest, helper.ErrInvalidSubjectToken)
+		return
+	}
+	if subject.SessionID == 0 && !time.Now().Before(s.Env.SessionlessTokensUntil) {
+		tokenError(c, http.StatusBadRequest, oauthInvalidRequest, helper.ErrInvalidSubjectToken)
+		return
+	}
+	if subject.SessionID != 0 {
+		session, err := s.ts.GetTokenByID(subject.SessionID)
+		if err != nil || session.UserID != subject.ID || session.Type != domain.REFRESH {
+			tokenError(c, http.StatusBadRequest, oauthInvalidRequest, helper.ErrInvalidSubjectToken)
+			return
+		}
+	}
+	if details.ActorToken != "" {
+		if !isSupportedTokenType(details.ActorTokenType) {
+			tokenError(c, http.StatusBadRequest, oauthInvalidRequest, helper.ErrUnsupportedTokenType)
+			return
+		}
+		actor, err := util.VerifyAndExtract(details.ActorToken, s.Env.AccessTokenSecret)
+		if err != nil || len(actor.Audience) > 0 || actor.Role != domain.ServiceRole || actor.ID != client.ID {
+			tokenError(c, http.StatusBadRequest, oauthInvalidRequest, helper.ErrInvalidActorToken)
+			return
+		}
+	}
+
+	// tokens without a scope stand for the default scopes, not for every
+	// scope there is
+	granted := subject.Scope
+	if granted == "" {
+		granted = strings.Join(s.Env.TokenExchangeDefaultScopes, " ")
+	}
+	scope := details.Scope
+	if scope == "" {
+		scope = granted
+	}
+	if strings.TrimSpace(scope) == "" || !util.IsSubScope(scope, granted) {
+		tokenError(c, http.StatusBadRequest, oauthInvalidScope, helper.ErrInvalidScope)
+		return
+	}
+
+	accessToken, expires, err := util.CreateExchangedToken(
+		subject,
+		&util.JwtCustomClaims{ID: client.ID, Username: client.Username},
+		details.Audience,
+		scope,
+		s.Env.AccessTokenSecret,
+		s.Env.AccessTokenExpiryHour,
+	)
+	if err != nil {
+		s.L.Print(err)
+		tokenError(c, http.StatusInternalServerError, oauthServerError, helper.ErrInternalError)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, TokenExchangeResponse{
+		AccessToken:     accessToken,
+		IssuedTokenType: util.TokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(time.Until(expires).Seconds()),
+		Scope:           scope,
+	})
+}
+
+// exchangeClient authenticates the calling service principal with HTTP
+// basic auth, or client_id and client_secret in the body, against its
+// account password.
+func (s *AuthHandler) exchangeClient(c *gin.Context, id, secret string) (*domain.User, bool) {
+	if basicID, basicSecret, ok := c.Request.BasicAuth(); ok {
+		id, secret = basicID, basicSecret
+	}
+	fail := func() (*domain.User, bool) {
+		c.Header("WWW-Authenticate", `Basic realm="token"`)
+		tokenError(c, http.StatusUnauthorized, oauthInvalidClient, helper.ErrInvalidClient)
+		return nil, false
+	}
+	if id == "" || secret == "" {
+		return fail()
+	}
+	client := &domain.User{}
+	err := s.Db.GetClient().Where("username = ? AND role = ?", id, domain.ServiceRole).First(client).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			s.L.Print(err)
+			tokenError(c, http.StatusInternalServerError, oauthServerError, helper.ErrInternalError)
+			return nil, false
+		}
+		s.hasher.Dummy(secret)
+		return fail()
+	}
+	ok, _, err := s.hasher.Verify(secret, client.Password)
+	if err != nil || !ok || services.IsSuspended(client, time.Now()) {
+		return fail()
+	}
+	return client, true
+}
+
+func (s *AuthHandler) isExchangeAudience(audience string) bool {
+	for _, a := range s.Env.TokenExchangeAudiences {
+		if a != "" && a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+func isSupportedTokenType(t string) bool {
+	return t == util.TokenTypeAccessToken || t == util.TokenTypeJWT
+}
+
+// tokenError writes an RFC 6749 section 5.2 error response.
+func tokenError(c *gin.Context, status int, code, description string) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+func TestExchangeToken(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Env.TokenExchangeAudiences = []string{"billing"}
+	ts.Env.TokenExchangeDefaultScopes = []string{"profile"}
+	user := ts.createUser(t, "ada", domain.UserRole, "correct horse")
+	client := ts.createUser(t, "orders", domain.ServiceRole, "client secret")
+
+	subjectToken, _, err := login(user, ts.AuthHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userToken := subjectToken
+	clientToken, _, err := login(client, ts.AuthHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionlessToken, err := util.CreateAccessToken(user, testSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(overrides map[string]string) map[string]string {
+		body := map[string]string{
+			"grant_type":         util.GrantTypeTokenExchange,
+			"subject_token":      subjectToken,
+			"subject_token_type": util.TokenTypeAccessToken,
+			"audience":           "billing",
+			"client_id":          "orders",
+			"client_secret":      "client secret",
+		}
+		for k, v := range overrides {
+			body[k] = v
+		}
+		return body
+	}
+
+	w := serve(ts.ExchangeToken, http.MethodPost, "/auth/token", request(nil), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("exchange = %d %s", w.Code, w.Body)
+	}
+	var resp TokenExchangeResponse
+	decode(t, w, &resp)
+	if resp.Scope != "profile" || resp.TokenType != "Bearer" {
+		t.Errorf("response = %+v, want the default scope", resp)
+	}
+	claims, err := util.VerifyAndExtract(resp.AccessToken, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.ID != user.ID || claims.Act == nil || claims.Act.Username != "orders" {
+		t.Errorf("claims = %+v, want the client as actor", claims)
+	}
+
+	failures := []struct {
+		name      string
+		overrides map[string]string
+		status    int
+		code      string
+	}{
+		{"no client", map[string]string{"client_secret": ""}, http.StatusUnauthorized, oauthInvalidClient},
+		{"wrong secret", map[string]string{"client_secret": "guess"}, http.StatusUnauthorized, oauthInvalidClient},
+		{"user as client", map[string]string{"client_id": "ada", "client_secret": "correct horse"}, http.StatusUnauthorized, oauthInvalidClient},
+		{"scope beyond default", map[string]string{"scope": "profile admin"}, http.StatusBadRequest, oauthInvalidScope},
+		{"unknown audience", map[string]string{"audience": "payroll"}, http.StatusBadRequest, oauthInvalidTarget},
+		{"user as actor", map[string]string{"actor_token": userToken, "actor_token_type": util.TokenTypeAccessToken}, http.StatusBadRequest, oauthInvalidRequest},
+		{"service as subject", map[string]string{"subject_token": clientToken}, http.StatusBadRequest, oauthInvalidRequest},
+		{"sessionless subject", map[string]string{"subject_token": sessionlessToken}, http.StatusBadRequest, oauthInvalidRequest},
+		{"other grant", map[string]string{"grant_type": "password"}, http.StatusBadRequest, oauthUnsupportedGrantType},
+	}
+	for _, tc := range failures {
+		w := serve(ts.ExchangeToken, http.MethodPost, "/auth/token", request(tc.overrides), nil)
+		var body struct {
+			Error string `json:"error"`
+		}
+		decode(t, w, &body)
+		if w.Code != tc.status || body.Error != tc.code {
+			t.Errorf("%s: got %d %s, want %d %s", tc.name, w.Code, body.Error, tc.status, tc.code)
+		}
+	}
+
+	// the exchanged token can only be exchanged again by its audience
+	subjectToken = resp.AccessToken
+	w = serve(ts.ExchangeToken, http.MethodPost, "/auth/token", request(nil), nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("exchange of a token issued to billing by orders = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+)
+
+// ExportUsers streams the users matching the GetUsers filters as CSV or
+// NDJSON. Once streaming has started errors can only cut the download
+// short.
+func (s *UsersHandler) ExportUsers(c *gin.Context) {
+	format := c.DefaultQuery("format", services.CSVFormat)
+	contentType := "text/csv"
+	switch format {
+	case services.CSVFormat:
+	case services.NDJSONFormat:
+		contentType = "application/x-ndjson"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrUnknownFormat.Error()})
+		return
+	}
+	filter, err := userFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// check the filter now, once streaming starts errors can't be reported
+	if _, err := filter.Order(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidFilter + ": " + err.Error()})
+		return
+	}
+	if _, err := filter.Apply(s.Db.GetClient()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidFilter + ": " + err.Error()})
+		return
+	}
+
+	audit(c, s.audit, s.L, "user.export", "user", 0, c.Request.URL.Query())
+	filename := fmt.Sprintf("users-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+	if err := s.exporter.Export(c.Writer, format, filter); err != nil {
+		s.L.Print(err)
+		c.Abort()
+	}
+}
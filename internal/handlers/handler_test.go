@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+const testSecret = "secret"
+
+type sentMail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// testMailer keeps the emails it is asked to send. When gate is set
+// sending waits until it is closed.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []sentMail
+	gate chan struct{}
+}
+
+func (m *testMailer) SendMail(from, to, subject, body string) error {
+	if m.gate != nil {
+		<-m.gate
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, sentMail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+func (m *testMailer) Sent() []sentMail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]sentMail(nil), m.sent...)
+}
+
+// Wait returns the sent emails once there are at least n of them, for
+// emails sent in the background.
+func (m *testMailer) Wait(t *testing.T, n int) []sentMail {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		sent := m.Sent()
+		if len(sent) >= n {
+			return sent
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d emails sent, want %d", len(sent), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func testEnv() *domain.Env {
+	return &domain.Env{
+		AccessTokenExpiryHour:      1,
+		RefreshTokenExpiryHour:     1,
+		AccessTokenSecret:          testSecret,
+		RefreshTokenSecret:         testSecret,
+		ConfirmCodeLength:          6,
+		CodeMaxAttempts:            5,
+		ConfirmationCodeExpiryHour: 1,
+		AuthBackend:                services.LocalBackend,
+		AdminAuthBackend:           services.LocalBackend,
+		PasswordMinLength:          8,
+		PasswordMaxLength:          72,
+		PasswordDisallowIdentity:   true,
+		PasswordHistorySize:        5,
+		PasswordChangeTokenMinutes: 10,
+		LockoutThreshold:           3,
+		LockoutBaseMinutes:         1,
+		LockoutMaxMinutes:          60,
+		InviteExpiryHour:           72,
+		AccountDeletionGraceDays:   14,
+	}
+}
+
+type testServer struct {
+	*AuthHandler
+	db     *gorm.DB
+	mailer *testMailer
+	hasher services.PasswordHasher
+}
+
+// newTestServer wires an AuthHandler to an empty database. env may be
+// adjusted before the first request.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db := dbtest.New(t)
+	env := testEnv()
+	server := &domain.Server{
+		Db:  dbtest.Service(db),
+		Env: env,
+		L:   log.New(io.Discard, "", 0),
+	}
+	hasher := services.NewPasswordHasher(services.PasswordHasherConfig{
+		Algorithm:  services.BcryptAlgorithm,
+		BcryptCost: bcrypt.MinCost,
+	})
+	policy, err := services.NewPasswordPolicy(services.NewPasswordPolicyConfig(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	network, err := services.NewNetworkPolicy(env, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := &testMailer{}
+	ah := NewAuthHandler(
+		server,
+		mailer,
+		services.NewTokenService(db, env.CodeMaxAttempts),
+		services.NewLocalVerifier(db, hasher),
+		services.NewLocalVerifier(db, hasher),
+		policy,
+		hasher,
+		services.NewPasswordHistoryService(db, hasher, env.PasswordHistorySize),
+		services.NewLockoutService(services.NewLockoutConfig(env), db),
+		services.NewLoginHistoryService(db),
+		network,
+		services.NewAdminGuard(),
+	)
+	return &testServer{AuthHandler: ah, db: db, mailer: mailer, hasher: hasher}
+}
+
+// usersHandler wires a UsersHandler to the same database.
+func (ts *testServer) usersHandler() *UsersHandler {
+	return NewUsersHandler(
+		ts.Server,
+		services.NewSuspensionService(ts.db),
+		services.NewUserImporter(ts.db, ts.hasher),
+		services.NewUserExporter(ts.db),
+		services.NewUserRetentionService(ts.db),
+		services.NewAuditService(ts.db),
+		services.NewAdminGuard(),
+	)
+}
+
+// sessions returns the IDs of the user's refresh tokens.
+func (ts *testServer) sessions(t *testing.T, user *domain.User) []uint {
+	t.Helper()
+	var ids []uint
+	err := ts.db.Model(&domain.Token{}).
+		Where("user_id = ? AND type = ?", user.ID, domain.REFRESH).
+		Order("id").
+		Pluck("id", &ids).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// createUser stores a local user with password, as SignUp would.
+func (ts *testServer) createUser(t *testing.T, username string, role domain.Role, password string) *domain.User {
+	t.Helper()
+	hash, err := ts.hasher.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	user := &domain.User{
+		Username:          username,
+		Email:             username + "@example.com",
+		Password:          hash,
+		Role:              role,
+		PasswordChangedAt: &now,
+	}
+	if err := ts.db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.history.Record(user.ID, hash); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// serve runs handler on a request with a JSON body. A non-nil payload is
+// set as the signed in user, as JwtAuthMiddleware would.
+func serve(
+	handler gin.HandlerFunc,
+	method string,
+	path string,
+	body interface{},
+	payload *util.JwtCustomClaims,
+	params ...gin.Param,
+) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		buf, _ := json.Marshal(body)
+		reader = bytes.NewReader(buf)
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, path, reader)
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = params
+	if payload != nil {
+		c.Set("payload", payload)
+	}
+	handler(c)
+	return w
+}
+
+// payloadFor returns the claims of an access token issued to user.
+func payloadFor(user *domain.User) *util.JwtCustomClaims {
+	return &util.JwtCustomClaims{ID: user.ID, Username: user.Username, Role: user.Role}
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body.String(), err)
+	}
+}
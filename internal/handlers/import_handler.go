@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+)
+
+// ImportUsers loads users from a CSV or NDJSON upload, either the request
+// body itself or a multipart file field. The format comes from the format
+// parameter or else the content type. With dry_run=true the rows are only
+// checked.
+func (s *UsersHandler) ImportUsers(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	format := c.Query("format")
+	var body io.Reader = c.Request.Body
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+			return
+		}
+		defer f.Close()
+		body = f
+		if format == "" {
+			format = importFormat(file.Header.Get("Content-Type"))
+		}
+	} else if format == "" {
+		format = importFormat(c.ContentType())
+	}
+
+	report, err := s.importer.Import(body, format, dryRun)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !dryRun {
+		audit(c, s.audit, s.L, "user.import", "user", 0, gin.H{
+			"total":   report.Total,
+			"created": report.Created,
+			"updated": report.Updated,
+			"failed":  report.Failed,
+		})
+	}
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    report,
+	})
+}
+
+func importFormat(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return services.CSVFormat
+	case "application/x-ndjson", "application/jsonl":
+		return services.NDJSONFormat
+	}
+	return ""
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+// linkCodeLength is longer than the emailed codes since these codes travel
+// in links and are never typed.
+const linkCodeLength = 32
+
+// InviteHandler lets admins create accounts, either with a password they
+// choose or by inviting the owner to choose one.
+type InviteHandler struct {
+	*AuthHandler
+	audit services.AuditService
+}
+
+func NewInviteHandler(ah *AuthHandler, audit services.AuditService) *InviteHandler {
+	return &InviteHandler{AuthHandler: ah, audit: audit}
+}
+
+// CreateUser creates an account with the given role. Without a password
+// the account can't be signed in to until the emailed invite is accepted.
+// With one, the user has to change it at their first sign-in.
+func (s *InviteHandler) CreateUser(c *gin.Context) {
+	var details struct {
+		Email     string      `json:"email"`
+		Username  string      `json:"username"`
+		Firstname string      `json:"firstname"`
+		Lastname  string      `json:"lastname"`
+		Role      domain.Role `json:"role"`
+		Password  string      `json:"password"`
+	}
+	if c.Bind(&details) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+		return
+	}
+	if details.Email == "" || details.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+		return
+	}
+	if details.Role == "" {
+		details.Role = domain.UserRole
+	}
+	if _, known := domain.Roles[details.Role]; !known {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidRole})
+		return
+	}
+	payload, err := util.GetPayload(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrFailParsePayload})
+		return
+	}
+
+	user := domain.User{
+		Email:     details.Email,
+		Username:  details.Username,
+		Firstname: details.Firstname,
+		Lastname:  details.Lastname,
+		Role:      details.Role,
+	}
+	invite := details.Password == ""
+	password := details.Password
+	if invite {
+		// nobody knows this one, the invitee replaces it
+		if password, err = util.GenerateSecret(32); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+			return
+		}
+	} else if violations := s.policy.Validate(password, &user); len(violations) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      helper.ErrWeakPassword,
+			"violations": violations,
+		})
+		return
+	}
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrFailHash})
+		return
+	}
+
+	var existing []domain.User
+	err = s.Db.GetClient().Unscoped().
+		Where("email = ? OR username = ?", user.Email, user.Username).
+		Find(&existing).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	for _, other := range existing {
+		if other.Username == user.Username {
+			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrExistingUsername})
+			return
+		}
+	}
+	if len(existing) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrExistingEmail})
+		return
+	}
+
+	now := time.Now()
+	user.Password = hash
+	if invite {
+		user.InvitedAt = &now
+		user.InvitedByID = &payload.ID
+	} else {
+		user.PasswordChangedAt = &now
+		user.MustChangePassword = true
+	}
+	if err := s.Db.GetClient().Create(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailCreate("user")})
+		return
+	}
+	if !invite {
+		if err := s.history.Record(user.ID, hash); err != nil {
+			s.L.Print(err)
+		}
+		audit(c, s.audit, s.L, "user.create", "user", user.ID, gin.H{"role": user.Role})
+		c.JSON(http.StatusCreated, domain.Response{Message: helper.Success, Data: &user})
+		return
+	}
+
+	audit(c, s.audit, s.L, "invite.create", "user", user.ID, gin.H{"role": user.Role})
+	if !s.sendInvite(&user) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrCannotSendMail})
+		return
+	}
+	c.JSON(http.StatusCreated, domain.Response{Message: helper.InviteSent, Data: &user})
+}
+
+// GetInvites lists the invited users who haven't accepted yet.
+func (s *InviteHandler) GetInvites(c *gin.Context) {
+	var users []domain.User
+	err := pendingInvites(s.Db.GetClient()).
+		Order("invited_at desc").
+		Find(&users).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailGet("invites")})
+		return
+	}
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    &users,
+	})
+}
+
+// ResendInvite emails a new link, the previous one stops working.
+func (s *InviteHandler) ResendInvite(c *gin.Context) {
+	user, ok := s.pendingInvite(c)
+	if !ok {
+		return
+	}
+	if !s.sendInvite(user) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrCannotSendMail})
+		return
+	}
+	audit(c, s.audit, s.L, "invite.resend", "user", user.ID, nil)
+	c.JSON(http.StatusOK, domain.Response{Message: helper.InviteSent})
+}
+
+// RevokeInvite deletes the account outright, it was never used, so its
+// email and username can be invited again.
+func (s *InviteHandler) RevokeInvite(c *gin.Context) {
+	user, ok := s.pendingInvite(c)
+	if !ok {
+		return
+	}
+	err := s.Db.GetClient().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&domain.Token{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(user).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailDelete("invite")})
+		return
+	}
+	audit(c, s.audit, s.L, "invite.revoke", "user", user.ID, nil)
+	c.JSON(http.StatusOK, gin.H{"message": helper.Success})
+}
+
+// AcceptInvite sets the invitee's password. Following the emailed link
+// proves they own the address, so it is marked verified too.
+func (s *InviteHandler) AcceptInvite(c *gin.Context) {
+	var details struct {
+		Email    string `json:"email"`
+		Code     string `json:"code"`
+		Password string `json:"password"`
+	}
+	if c.Bind(&details) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+		return
+	}
+	user, ok := s.consumeCodeIf(c, domain.INVITE, details.Email, details.Code,
+		func(user *domain.User) bool {
+			return s.validPassword(c, user, details.Password)
+		})
+	if !ok {
+		return
+	}
+	now := time.Now()
+	user.IsEmailVerified = true
+	user.VerifiedAt = now
+	user.InviteAcceptedAt = &now
+	if !s.savePassword(c, user, details.Password) {
+		return
+	}
+	audit(c, s.audit, s.L, "invite.accept", "user", user.ID, nil)
+	c.JSON(http.StatusOK, domain.Response{Message: helper.Success})
+}
+
+func (s *InviteHandler) pendingInvite(c *gin.Context) (*domain.User, bool) {
+	id, ok := util.GetIDParam(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("invite")})
+		return nil, false
+	}
+	user := &domain.User{}
+	err := pendingInvites(s.Db.GetClient()).First(user, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("invite")})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return nil, false
+	}
+	return user, true
+}
+
+// pendingInvites restricts db to invited users who haven't taken over their
+// account: by accepting, by setting a password some other way, or by
+// signing in through a directory or identity provider.
+func pendingInvites(db *gorm.DB) *gorm.DB {
+	return db.Where("invited_at IS NOT NULL AND invite_accepted_at IS NULL AND password_changed_at IS NULL").
+		Where(
+			"NOT EXISTS (SELECT 1 FROM login_attempts WHERE login_attempts.user_id = users.id AND outcome = ?)",
+			domain.LoginSucceeded,
+		)
+}
+
+// sendInvite issues a fresh invite code for user and emails the link.
+func (s *InviteHandler) sendInvite(user *domain.User) bool {
+	expires := time.Now().Add(time.Duration(s.Env.InviteExpiryHour) * time.Hour)
+	code, err := s.ts.IssueCode(domain.INVITE, user.ID, linkCodeLength, expires)
+	if err != nil {
+		s.L.Print(err)
+		return false
+	}
+	link := fmt.Sprintf(
+		"%s?email=%s&code=%s",
+		s.Env.InviteURL,
+		url.QueryEscape(user.Email),
+		url.QueryEscape(code),
+	)
+	return s.sendAccountMail(
+		user.Email,
+		"You have been invited",
+		fmt.Sprintf(
+			"An account %s has been created for you. Choose a password to start using it: %s\n"+
+				"The link expires on %s.",
+			user.Username, link, expires.UTC().Format(time.RFC1123),
+		),
+	)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/services"
+)
+
+var inviteLink = regexp.MustCompile(`\?email=\S+&code=(\S+)`)
+
+func (ts *testServer) inviteHandler() *InviteHandler {
+	return NewInviteHandler(ts.AuthHandler, services.NewAuditService(ts.db))
+}
+
+// invite has admin invite username and returns the invitee and the code
+// from the invite email.
+func (ts *testServer) invite(t *testing.T, admin *domain.User, username string) (*domain.User, string) {
+	t.Helper()
+	before := len(ts.mailer.Sent())
+	w := serve(ts.inviteHandler().CreateUser, http.MethodPost, "/admin/users", gin.H{
+		"email":    username + "@example.com",
+		"username": username,
+	}, payloadFor(admin))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("invite = %d %s", w.Code, w.Body)
+	}
+	user := &domain.User{}
+	ts.db.Where("username = ?", username).First(user)
+	for _, m := range ts.mailer.Sent()[before:] {
+		if match := inviteLink.FindStringSubmatch(m.Body); match != nil {
+			code, err := url.QueryUnescape(match[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return user, code
+		}
+	}
+	t.Fatalf("no invite link in %+v", ts.mailer.Sent()[before:])
+	return nil, ""
+}
+
+func TestAcceptInvite(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.createUser(t, "root", domain.AdminRole, "correct horse")
+	user, code := ts.invite(t, admin, "ada")
+
+	accept := func(password string) int {
+		return serve(ts.inviteHandler().AcceptInvite, http.MethodPost, "/auth/invite/accept", gin.H{
+			"email":    user.Email,
+			"code":     code,
+			"password": password,
+		}, nil).Code
+	}
+	if got := accept("ada-is-me-ada"); got != http.StatusBadRequest {
+		t.Errorf("accept with a password containing the username = %d, want 400", got)
+	}
+	if got := accept("short"); got != http.StatusBadRequest {
+		t.Errorf("accept with a short password = %d, want 400", got)
+	}
+	if got := accept("battery staple"); got != http.StatusOK {
+		t.Fatalf("accept after refused passwords = %d, want the code to still work", got)
+	}
+	if got := accept("another staple"); got != http.StatusBadRequest {
+		t.Errorf("accepting twice = %d, want 400", got)
+	}
+	stored := &domain.User{}
+	ts.db.First(stored, user.ID)
+	if stored.InviteAcceptedAt == nil || !stored.IsEmailVerified {
+		t.Errorf("accepted invite left %+v", stored)
+	}
+}
+
+func TestRevokeInvite(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.createUser(t, "root", domain.AdminRole, "correct horse")
+	revoke := func(user *domain.User) int {
+		id := strconv.FormatUint(uint64(user.ID), 10)
+		return serve(ts.inviteHandler().RevokeInvite, http.MethodDelete, "/admin/invites/"+id, nil,
+			payloadFor(admin), gin.Param{Key: "id", Value: id}).Code
+	}
+
+	unused, _ := ts.invite(t, admin, "ada")
+	w := serve(ts.inviteHandler().RevokeInvite, http.MethodDelete, "/admin/invites/0", nil,
+		payloadFor(admin), gin.Param{Key: "id", Value: "0 OR 1=1"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("revoking with an id that isn't a number = %d, want 404", w.Code)
+	}
+	if got := revoke(unused); got != http.StatusOK {
+		t.Errorf("revoking an unused invite = %d, want 200", got)
+	}
+	if ts.db.Unscoped().First(&domain.User{}, unused.ID).Error == nil {
+		t.Error("revoked invitee still exists")
+	}
+
+	reset, _ := ts.invite(t, admin, "bob")
+	code, err := ts.issueCode(domain.RESET_PASSWORD, reset.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = serve(ts.ResetPassword, http.MethodPost, "/auth/reset-password/confirm", gin.H{
+		"email":        reset.Email,
+		"code":         code,
+		"new_password": "battery staple",
+	}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset = %d %s", w.Code, w.Body)
+	}
+	stored := &domain.User{}
+	ts.db.First(stored, reset.ID)
+	if stored.InviteAcceptedAt == nil {
+		t.Error("resetting the password left the invite pending")
+	}
+	if got := revoke(reset); got != http.StatusNotFound {
+		t.Errorf("revoking after a password reset = %d, want 404", got)
+	}
+
+	// rows from before invites were settled by any password change
+	changed, _ := ts.invite(t, admin, "cy")
+	ts.db.Model(changed).Update("password_changed_at", time.Now())
+	if got := revoke(changed); got != http.StatusNotFound {
+		t.Errorf("revoking after a password change = %d, want 404", got)
+	}
+
+	signedIn, _ := ts.invite(t, admin, "dee")
+	ts.db.Create(&domain.LoginAttempt{UserID: &signedIn.ID, Method: services.SAMLProvider, Outcome: domain.LoginSucceeded})
+	if got := revoke(signedIn); got != http.StatusNotFound {
+		t.Errorf("revoking after a sign-in = %d, want 404", got)
+	}
+	for _, user := range []*domain.User{reset, changed, signedIn} {
+		if ts.db.First(&domain.User{}, user.ID).Error != nil {
+			t.Errorf("%s was deleted", user.Username)
+		}
+	}
+
+	var invites domain.Response
+	decode(t, serve(ts.inviteHandler().GetInvites, http.MethodGet, "/admin/invites", nil, payloadFor(admin)), &invites)
+	if pending, _ := invites.Data.([]interface{}); len(pending) != 0 {
+		t.Errorf("invites = %v, want none pending", invites.Data)
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+// RequestUnlock emails a fresh unlock code to a locked account. The
+// response is the same whether or not the account exists or is locked,
+// and the code is issued and sent in the background so that the timing
+// doesn't tell either.
+func (s *AuthHandler) RequestUnlock(c *gin.Context) {
+	var details struct {
+		Email string `json:"email"`
+	}
+
+	err := c.ShouldBind(&details)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := domain.User{}
+	err = s.Db.GetClient().
+		Where("email = ? AND locked_until > ?", details.Email, time.Now()).
+		First(&user).Error
+	if err == nil {
+		go s.sendUnlockEmail(&user)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.L.Print(err)
+	}
+
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.UnlockEmailSent,
+	})
+}
+
+func (s *AuthHandler) ConfirmUnlock(c *gin.Context) {
+	var details struct {
+		Email string `json:"email"`
+		Code  string `json:"code"`
+	}
+
+	err := c.ShouldBind(&details)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, ok := s.consumeCode(c, domain.UNLOCK_ACCOUNT, details.Email, details.Code)
+	if !ok {
+		return
+	}
+
+	if err := s.lockout.Unlock(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+	})
+}
+
+// GetLockouts lists accounts that are locked or have failed sign-ins
+// counting towards a lockout.
+func (s *AuthHandler) GetLockouts(c *gin.Context) {
+	var users []domain.User
+	err := s.Db.GetClient().
+		Where("locked_until > ? OR failed_login_attempts > 0", time.Now()).
+		Order("locked_until desc nulls last").
+		Find(&users).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailGet("lockouts")})
+		return
+	}
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    &users,
+	})
+}
+
+func (s *AuthHandler) ClearLockout(c *gin.Context) {
+	id, ok := util.GetIDParam(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("user")})
+		return
+	}
+	user := domain.User{}
+	if err := s.Db.GetClient().First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("user")})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	if err := s.lockout.Unlock(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	if err := s.ts.RevokeUserTokens(user.ID, domain.UNLOCK_ACCOUNT, 0); err != nil {
+		s.L.Print(err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": helper.Success})
+}
+
+// failSignIn counts a failed sign-in and tells the owner when it locks
+// their account.
+func (s *AuthHandler) failSignIn(identifier string) {
+	user, locked, err := s.lockout.Fail(identifier)
+	if err != nil {
+		s.L.Print(err)
+		return
+	}
+	if locked {
+		s.sendUnlockEmail(user)
+	}
+}
+
+func (s *AuthHandler) sendUnlockEmail(user *domain.User) {
+	code, err := s.issueCode(domain.UNLOCK_ACCOUNT, user.ID)
+	if err != nil {
+		s.L.Print(err)
+		return
+	}
+	err = s.mailer.SendMail(
+		s.Env.POSTMARK_FROM_EMAIL,
+		user.Email,
+		"Your account has been locked",
+		fmt.Sprintf(
+			"Your account was locked after too many failed sign-ins. It unlocks by itself at %s, or use code %s to unlock it now.",
+			user.LockedUntil.UTC().Format(time.RFC1123),
+			code,
+		),
+	)
+	if err != nil {
+		s.L.Print(err)
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+var unlockCode = regexp.MustCompile(`use code (\S+) to unlock`)
+
+func TestLockout(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.createUser(t, "root", domain.AdminRole, "correct horse")
+	user := ts.createUser(t, "ada", domain.UserRole, "correct horse")
+
+	signIn := func(password string) int {
+		return serve(ts.SignIn, http.MethodPost, "/auth/user/signin", gin.H{
+			"email":    user.Email,
+			"password": password,
+		}, nil).Code
+	}
+	// lock returns the code mailed when the account is locked
+	lock := func() string {
+		t.Helper()
+		before := len(ts.mailer.Sent())
+		for i := 0; i < int(ts.Env.LockoutThreshold); i++ {
+			if code := signIn("wrong horse"); code != http.StatusUnauthorized {
+				t.Fatalf("sign-in with a wrong password = %d", code)
+			}
+		}
+		for _, m := range ts.mailer.Sent()[before:] {
+			if m.To == user.Email && m.Subject == "Your account has been locked" {
+				if match := unlockCode.FindStringSubmatch(m.Body); match != nil {
+					return match[1]
+				}
+			}
+		}
+		t.Fatalf("no unlock code in %+v", ts.mailer.Sent())
+		return ""
+	}
+	confirm := func(code string) int {
+		return serve(ts.ConfirmUnlock, http.MethodPost, "/auth/unlock/confirm", gin.H{
+			"email": user.Email,
+			"code":  code,
+		}, nil).Code
+	}
+
+	code := lock()
+	if got := signIn("correct horse"); got != http.StatusUnauthorized {
+		t.Errorf("sign-in while locked = %d, want 401", got)
+	}
+	if got := confirm("000000"); got != http.StatusBadRequest {
+		t.Errorf("unlock with a wrong code = %d, want 400", got)
+	}
+	if got := confirm(code); got != http.StatusOK {
+		t.Fatalf("unlock = %d", got)
+	}
+	if got := signIn("correct horse"); got != http.StatusOK {
+		t.Errorf("sign-in after unlock = %d, want 200", got)
+	}
+
+	// a code asked for again arrives in the background
+	lock()
+	before := len(ts.mailer.Sent())
+	for _, email := range []string{user.Email, "nobody@example.com"} {
+		w := serve(ts.RequestUnlock, http.MethodPost, "/auth/unlock/request", gin.H{"email": email}, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("unlock request for %s = %d, want 200", email, w.Code)
+		}
+	}
+	sent := ts.mailer.Wait(t, before+1)[before:]
+	match := unlockCode.FindStringSubmatch(sent[0].Body)
+	if len(sent) != 1 || sent[0].To != user.Email || match == nil {
+		t.Fatalf("unlock request mail = %+v", sent)
+	}
+	if got := confirm(match[1]); got != http.StatusOK {
+		t.Fatalf("unlock with a requested code = %d", got)
+	}
+
+	// an admin clearing the lockout also voids the mailed code
+	code = lock()
+	w := serve(ts.ClearLockout, http.MethodDelete, "/", nil, payloadFor(admin),
+		gin.Param{Key: "id", Value: "0 OR 1=1"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("clear with an id that isn't a number = %d, want 404", w.Code)
+	}
+	w = serve(ts.ClearLockout, http.MethodDelete, "/", nil, payloadFor(admin),
+		gin.Param{Key: "id", Value: strconv.FormatUint(uint64(user.ID), 10)})
+	if w.Code != http.StatusOK {
+		t.Fatalf("clear = %d %s", w.Code, w.Body)
+	}
+	if got := confirm(code); got != http.StatusBadRequest {
+		t.Errorf("unlock with a code issued before the clear = %d, want 400", got)
+	}
+	if got := signIn("correct horse"); got != http.StatusOK {
+		t.Errorf("sign-in after clear = %d, want 200", got)
+	}
+}
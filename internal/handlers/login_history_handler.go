@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+const (
+	defaultLoginHistoryLimit = 50
+	maxLoginHistoryLimit     = 200
+)
+
+// GetLogins lists the signed in user's sign-in attempts newest first. Older
+// attempts are reached by following the cursors in the page meta.
+func (s *AuthHandler) GetLogins(c *gin.Context) {
+	payload, err := util.GetPayload(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrFailParsePayload})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLoginHistoryLimit)))
+	if err != nil || limit <= 0 || limit > maxLoginHistoryLimit {
+		limit = defaultLoginHistoryLimit
+	}
+	cursor, err := util.GetCursorParam(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	attempts, meta, err := s.logins.List(payload.ID, cursor, limit)
+	if errors.Is(err, util.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailGet("login history")})
+		return
+	}
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    &attempts,
+		Meta:    meta,
+	})
+}
+
+// recordLogin stores a sign-in attempt and warns the owner when a
+// successful one comes from a device or network not seen before.
+func (s *AuthHandler) recordLogin(
+	c *gin.Context,
+	identifier string,
+	user *domain.User,
+	method string,
+	outcome domain.LoginOutcome,
+) {
+	if method == "" {
+		method = services.LocalBackend
+	}
+	attempt := &domain.LoginAttempt{
+		Identifier: identifier,
+		Method:     method,
+		Outcome:    outcome,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}
+	if user != nil {
+		attempt.UserID = &user.ID
+	}
+	newDevice, err := s.logins.Record(attempt)
+	if err != nil {
+		s.L.Print(err)
+		return
+	}
+	if !newDevice {
+		return
+	}
+	// the alert is sent in the background so it doesn't hold up the sign-in
+	to := user.Email
+	body := fmt.Sprintf(
+		"Your account was signed in to at %s from %s using %s. If this wasn't you, change your password now.",
+		attempt.CreatedAt.UTC().Format(time.RFC1123),
+		attempt.IP,
+		attempt.UserAgent,
+	)
+	go func() {
+		err := s.mailer.SendMail(s.Env.POSTMARK_FROM_EMAIL, to, "New sign-in to your account", body)
+		if err != nil {
+			s.L.Print(err)
+		}
+	}()
+}
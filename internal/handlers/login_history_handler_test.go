@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func TestNewDeviceAlert(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "ada", domain.UserRole, "correct horse")
+	signedInWith := func(agent string) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/auth/user/signin", nil)
+		c.Request.RemoteAddr = "198.51.100.1:5000"
+		c.Request.Header.Set("User-Agent", agent)
+		ts.recordLogin(c, user.Email, user, "", domain.LoginSucceeded)
+	}
+
+	signedInWith("firefox")
+	signedInWith("firefox")
+	signedInWith("safari")
+	sent := ts.mailer.Wait(t, 1)
+	if len(sent) != 1 || sent[0].To != user.Email || sent[0].Subject != "New sign-in to your account" {
+		t.Errorf("mail = %+v, want one new sign-in alert", sent)
+	}
+}
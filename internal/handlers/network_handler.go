@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+type NetworkHandler struct {
+	*domain.Server
+	network services.NetworkPolicy
+	audit   services.AuditService
+}
+
+func NewNetworkHandler(
+	s *domain.Server,
+	network services.NetworkPolicy,
+	audit services.AuditService,
+) *NetworkHandler {
+	return &NetworkHandler{Server: s, network: network, audit: audit}
+}
+
+// GetRules lists every rule in force, including the ones configured in the
+// environment, which have no ID and can't be changed here.
+func (s *NetworkHandler) GetRules(c *gin.Context) {
+	rules, err := s.network.Rules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailGet("network rules")})
+		return
+	}
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    &rules,
+	})
+}
+
+func (s *NetworkHandler) CreateRule(c *gin.Context) {
+	rule := domain.NetworkRule{}
+	if c.Bind(&rule) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+		return
+	}
+	rule.ID = 0
+	if !s.validRule(c, &rule, nil) {
+		return
+	}
+	if err := s.Db.GetClient().Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailCreate("network rule")})
+		return
+	}
+	s.network.Reload()
+	audit(c, s.audit, s.L, "network_rule.create", "network_rule", rule.ID, &rule)
+	c.JSON(http.StatusCreated, domain.Response{
+		Message: helper.Success,
+		Data:    &rule,
+	})
+}
+
+func (s *NetworkHandler) UpdateRule(c *gin.Context) {
+	rule, ok := s.rule(c)
+	if !ok {
+		return
+	}
+	before := *rule
+	if c.Bind(rule) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+		return
+	}
+	rule.ID = before.ID
+	if !s.validRule(c, rule, &before) {
+		return
+	}
+	if err := s.Db.GetClient().Save(rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	s.network.Reload()
+	audit(c, s.audit, s.L, "network_rule.update", "network_rule", rule.ID, gin.H{
+		"before": &before,
+		"after":  rule,
+	})
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    rule,
+	})
+}
+
+func (s *NetworkHandler) RemoveRule(c *gin.Context) {
+	rule, ok := s.rule(c)
+	if !ok {
+		return
+	}
+	if !s.keepsAccess(c, nil, rule) {
+		return
+	}
+	if err := s.Db.GetClient().Delete(rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailDelete("network rule")})
+		return
+	}
+	s.network.Reload()
+	audit(c, s.audit, s.L, "network_rule.delete", "network_rule", rule.ID, rule)
+	c.JSON(http.StatusOK, gin.H{"message": helper.Success})
+}
+
+func (s *NetworkHandler) rule(c *gin.Context) (*domain.NetworkRule, bool) {
+	id, ok := util.GetIDParam(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("network rule")})
+		return nil, false
+	}
+	rule := &domain.NetworkRule{}
+	if err := s.Db.GetClient().First(rule, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("network rule")})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return nil, false
+	}
+	return rule, true
+}
+
+func (s *NetworkHandler) validRule(c *gin.Context, rule, replaces *domain.NetworkRule) bool {
+	if rule.Action != domain.NetworkAllow && rule.Action != domain.NetworkDeny {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidNetworkAction})
+		return false
+	}
+	if _, known := domain.Roles[rule.Role]; rule.Role != "" && !known {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidRole})
+		return false
+	}
+	if _, err := services.ParseCIDR(rule.CIDR); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return s.keepsAccess(c, rule, replaces)
+}
+
+// keepsAccess refuses a change that would lock the admin making it out.
+func (s *NetworkHandler) keepsAccess(c *gin.Context, add, remove *domain.NetworkRule) bool {
+	payload, err := util.GetPayload(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrFailParsePayload})
+		return false
+	}
+	current, err := s.network.Rules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return false
+	}
+	var rules []domain.NetworkRule
+	for _, r := range current {
+		if remove == nil || r.ID != remove.ID {
+			rules = append(rules, r)
+		}
+	}
+	if add != nil {
+		rules = append(rules, *add)
+	}
+	if !services.NetworkAllowed(rules, c.ClientIP(), payload.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrNetworkSelfLockout})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+// ChangeExpiredPassword completes a sign-in that was held back because the
+// password expired or an admin forced a reset. It takes the restricted token
+// from SignIn and answers with a normal LoginResponse.
+func (s *AuthHandler) ChangeExpiredPassword(c *gin.Context) {
+	var details struct {
+		Password string `json:"new_password"`
+	}
+
+	err := c.ShouldBind(&details)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	payload, err := util.GetPayload(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrFailParsePayload})
+		return
+	}
+	user := domain.User{}
+	if err := s.Db.GetClient().First(&user, payload.ID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": helper.ErrUnauthorized})
+		return
+	}
+	if !s.setPassword(c, &user, details.Password) {
+		return
+	}
+
+	accessToken, refreshToken, err := login(&user, s)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data: LoginResponse{
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+		},
+	})
+}
+
+// ChangePassword lets a signed in user replace their password. Every other
+// session is signed out and the owner is told by email.
+func (s *AuthHandler) ChangePassword(c *gin.Context) {
+	var details struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"new_password"`
+	}
+
+	err := c.ShouldBind(&details)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	payload, err := util.GetPayload(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrFailParsePayload})
+		return
+	}
+	user := domain.User{}
+	if err := s.Db.GetClient().First(&user, payload.ID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": helper.ErrUnauthorized})
+		return
+	}
+	if user.AuthProvider != "" && user.AuthProvider != services.LocalBackend {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrExternalPassword})
+		return
+	}
+	if !s.checkPassword(c, &user, details.CurrentPassword) {
+		return
+	}
+	if !s.setPassword(c, &user, details.Password) {
+		return
+	}
+
+	if err := s.ts.RevokeUserTokens(user.ID, domain.REFRESH, payload.SessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	err = s.mailer.SendMail(
+		s.Env.POSTMARK_FROM_EMAIL,
+		user.Email,
+		"Your password was changed",
+		fmt.Sprintf(
+			"The password for %s was changed at %s. If this wasn't you, reset your password now.",
+			user.Username,
+			time.Now().UTC().Format(time.RFC1123),
+		),
+	)
+	if err != nil {
+		s.L.Print(err)
+	}
+
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+	})
+}
+
+// checkPassword verifies the password of a signed in user, against their
+// directory for LDAP users. Wrong passwords count towards a lockout as
+// failed sign-ins do, so a stolen access token can't be used to guess it.
+// It writes the error response itself and reports whether the caller
+// should carry on.
+func (s *AuthHandler) checkPassword(c *gin.Context, user *domain.User, password string) bool {
+	locked, err := s.lockout.Locked(user.Email)
+	if err != nil {
+		s.L.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return false
+	}
+	if locked {
+		c.JSON(http.StatusForbidden, gin.H{"error": helper.ErrAccountLocked})
+		return false
+	}
+	ok, err := s.verifyPassword(user, password)
+	if err != nil {
+		s.L.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return false
+	}
+	if !ok {
+		s.failSignIn(user.Email)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": helper.ErrInvalidCredentials})
+		return false
+	}
+	if err := s.lockout.Succeed(user); err != nil {
+		s.L.Print(err)
+	}
+	return true
+}
+
+func (s *AuthHandler) verifyPassword(user *domain.User, password string) (bool, error) {
+	if user.AuthProvider != services.LDAPBackend {
+		ok, _, err := s.hasher.Verify(password, user.Password)
+		return err == nil && ok, nil
+	}
+	verifier := s.verifier
+	if user.Role == domain.AdminRole {
+		verifier = s.adminVerifier
+	}
+	verified, err := verifier.Verify(user.Username, password)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return verified.ID == user.ID, nil
+}
+
+// setPassword checks password against the policy and history, then stores
+// its hash on user. It writes the error response itself and reports
+// whether the caller should carry on.
+func (s *AuthHandler) setPassword(c *gin.Context, user *domain.User, password string) bool {
+	return s.validPassword(c, user, password) && s.savePassword(c, user, password)
+}
+
+// validPassword is the policy and history half of setPassword.
+func (s *AuthHandler) validPassword(c *gin.Context, user *domain.User, password string) bool {
+	if violations := s.policy.Validate(password, user); len(violations) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      helper.ErrWeakPassword,
+			"violations": violations,
+		})
+		return false
+	}
+
+	if err := s.history.Check(user, password); err != nil {
+		if errors.Is(err, services.ErrPasswordReused) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrPasswordReused})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return false
+	}
+	return true
+}
+
+// savePassword is the storing half of setPassword, for passwords already
+// checked by validPassword.
+func (s *AuthHandler) savePassword(c *gin.Context, user *domain.User, password string) bool {
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": helper.ErrFailHash,
+		})
+		return false
+	}
+	now := time.Now()
+	user.Password = hash
+	user.PasswordChangedAt = &now
+	user.MustChangePassword = false
+	// whoever set it has taken the account over from the invite
+	if user.InvitedAt != nil && user.InviteAcceptedAt == nil {
+		user.InviteAcceptedAt = &now
+	}
+
+	if err := s.Db.GetClient().Save(user).Error; err != nil {
+		c.JSON(500, gin.H{"error": helper.ErrInternalError})
+		return false
+	}
+	if err := s.history.Record(user.ID, hash); err != nil {
+		s.L.Print(err)
+	}
+	return true
+}
+
+// passwordChangeRequired reports whether user has to pick a new password
+// before getting a full session. Passwords owned by a directory or IdP
+// never expire here.
+func (s *AuthHandler) passwordChangeRequired(user *domain.User) bool {
+	if user.AuthProvider != "" && user.AuthProvider != services.LocalBackend {
+		return false
+	}
+	if user.MustChangePassword {
+		return true
+	}
+	if s.Env.PasswordMaxAgeDays == 0 {
+		return false
+	}
+	changed := user.CreatedAt
+	if user.PasswordChangedAt != nil {
+		changed = *user.PasswordChangedAt
+	}
+	maxAge := time.Duration(s.Env.PasswordMaxAgeDays) * 24 * time.Hour
+	return time.Since(changed) > maxAge
+}
+
+func (s *AuthHandler) issuePasswordChangeToken(c *gin.Context, user *domain.User) {
+	token, err := util.CreatePasswordChangeToken(
+		user,
+		s.Env.AccessTokenSecret,
+		time.Duration(s.Env.PasswordChangeTokenMinutes)*time.Minute,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.ErrPasswordChangeRequired,
+		Data: LoginResponse{
+			AccessToken:            token,
+			PasswordChangeRequired: true,
+		},
+	})
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+// signedIn starts a session for user as login does and returns the claims
+// of its access token.
+func (ts *testServer) signedIn(t *testing.T, user *domain.User) *util.JwtCustomClaims {
+	t.Helper()
+	accessToken, _, err := login(user, ts.AuthHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := util.VerifyAndExtract(accessToken, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestChangePassword(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "ada", domain.UserRole, "correct horse")
+	current := ts.signedIn(t, user)
+	ts.signedIn(t, user)
+	ts.signedIn(t, user)
+
+	change := func(currentPassword, password string) int {
+		return serve(ts.ChangePassword, http.MethodPost, "/users/me/password", gin.H{
+			"current_password": currentPassword,
+			"new_password":     password,
+		}, current).Code
+	}
+	if code := change("wrong horse", "battery staple"); code != http.StatusUnauthorized {
+		t.Errorf("change with a wrong current password = %d, want 401", code)
+	}
+	if code := change("correct horse", "correct horse"); code != http.StatusBadRequest {
+		t.Errorf("change to the same password = %d, want 400", code)
+	}
+	if got := ts.sessions(t, user); len(got) != 3 {
+		t.Fatalf("failed changes revoked sessions, %d left", len(got))
+	}
+
+	if code := change("correct horse", "battery staple"); code != http.StatusOK {
+		t.Fatalf("change = %d", code)
+	}
+	if got := ts.sessions(t, user); !slices.Equal(got, []uint{current.SessionID}) {
+		t.Errorf("sessions after change = %v, want only the current one %d", got, current.SessionID)
+	}
+	stored := &domain.User{}
+	ts.db.First(stored, user.ID)
+	if ok, _, _ := ts.hasher.Verify("battery staple", stored.Password); !ok {
+		t.Error("new password doesn't verify")
+	}
+	if sent := ts.mailer.Sent(); len(sent) != 1 || sent[0].Subject != "Your password was changed" {
+		t.Errorf("mail = %+v, want the change notice", sent)
+	}
+	if code := change("battery staple", "correct horse"); code != http.StatusBadRequest {
+		t.Errorf("change back to a recent password = %d, want 400", code)
+	}
+
+	ldapUser := ts.createUser(t, "bob", domain.UserRole, "correct horse")
+	ts.db.Model(ldapUser).Update("auth_provider", services.LDAPBackend)
+	w := serve(ts.ChangePassword, http.MethodPost, "/users/me/password", gin.H{
+		"current_password": "correct horse",
+		"new_password":     "battery staple",
+	}, payloadFor(ldapUser))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("change for a directory user = %d, want 400", w.Code)
+	}
+}
+
+func TestChangePasswordLockout(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "ada", domain.UserRole, "correct horse")
+	claims := ts.signedIn(t, user)
+	change := func(currentPassword string) int {
+		return serve(ts.ChangePassword, http.MethodPost, "/users/me/password", gin.H{
+			"current_password": currentPassword,
+			"new_password":     "battery staple",
+		}, claims).Code
+	}
+	for i := 0; i < int(ts.Env.LockoutThreshold); i++ {
+		if code := change("wrong horse"); code != http.StatusUnauthorized {
+			t.Fatalf("wrong current password %d = %d, want 401", i+1, code)
+		}
+	}
+	if code := change("correct horse"); code != http.StatusForbidden {
+		t.Errorf("change after too many wrong passwords = %d, want 403", code)
+	}
+	stored := &domain.User{}
+	ts.db.First(stored, user.ID)
+	if ok, _, _ := ts.hasher.Verify("correct horse", stored.Password); !ok {
+		t.Error("locked account's password was changed")
+	}
+}
+
+func TestPasswordChangeRequired(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Env.PasswordMaxAgeDays = 90
+	now := time.Now()
+	old := now.AddDate(0, 0, -91)
+	tests := []struct {
+		name string
+		user domain.User
+		want bool
+	}{
+		{"fresh", domain.User{PasswordChangedAt: &now}, false},
+		{"expired", domain.User{PasswordChangedAt: &old}, true},
+		{"never changed, old account", domain.User{CreatedAt: old}, true},
+		{"never changed, new account", domain.User{CreatedAt: now}, false},
+		{"forced", domain.User{PasswordChangedAt: &now, MustChangePassword: true}, true},
+		{"expired directory password", domain.User{PasswordChangedAt: &old, AuthProvider: services.LDAPBackend}, false},
+		{"forced single sign-on user", domain.User{MustChangePassword: true, AuthProvider: services.SAMLProvider}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ts.passwordChangeRequired(&tt.user); got != tt.want {
+				t.Errorf("passwordChangeRequired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	ts.Env.PasswordMaxAgeDays = 0
+	if ts.passwordChangeRequired(&domain.User{PasswordChangedAt: &old}) {
+		t.Error("passwords expire with PasswordMaxAgeDays 0")
+	}
+}
+
+func TestForcePasswordChange(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.createUser(t, "root", domain.AdminRole, "correct horse")
+	user := ts.createUser(t, "ada", domain.UserRole, "correct horse")
+	ts.signedIn(t, user)
+	ts.signedIn(t, user)
+
+	w := serve(ts.usersHandler().ForcePasswordChange, http.MethodPost, "/", nil, payloadFor(admin),
+		gin.Param{Key: "id", Value: "0 OR 1=1"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("force with an id that isn't a number = %d, want 404", w.Code)
+	}
+	w = serve(ts.usersHandler().ForcePasswordChange, http.MethodPost, "/", nil, payloadFor(admin),
+		gin.Param{Key: "id", Value: strconv.FormatUint(uint64(user.ID), 10)})
+	if w.Code != http.StatusOK {
+		t.Fatalf("force = %d %s", w.Code, w.Body)
+	}
+	if got := ts.sessions(t, user); len(got) != 0 {
+		t.Errorf("sessions after force = %v, want none", got)
+	}
+
+	w = serve(ts.SignIn, http.MethodPost, "/auth/user/signin", gin.H{
+		"email":    user.Email,
+		"password": "correct horse",
+	}, nil)
+	var resp struct {
+		Message string
+		Data    LoginResponse
+	}
+	decode(t, w, &resp)
+	if w.Code != http.StatusOK || !resp.Data.PasswordChangeRequired || resp.Data.RefreshToken != "" {
+		t.Fatalf("sign-in = %d %+v, want a password change token", w.Code, resp)
+	}
+	claims, err := util.VerifyAndExtract(resp.Data.AccessToken, testSecret)
+	if err != nil || claims.Scope != util.ScopePasswordChange {
+		t.Fatalf("sign-in token = %+v, %v", claims, err)
+	}
+
+	w = serve(ts.ChangeExpiredPassword, http.MethodPost, "/auth/password/change", gin.H{
+		"new_password": "correct horse",
+	}, claims)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("keeping the password = %d %s, want 400", w.Code, w.Body)
+	}
+	w = serve(ts.ChangeExpiredPassword, http.MethodPost, "/auth/password/change", gin.H{
+		"new_password": "battery staple",
+	}, claims)
+	decode(t, w, &resp)
+	if w.Code != http.StatusOK || resp.Message != helper.Success || resp.Data.RefreshToken == "" {
+		t.Errorf("change = %d %s, want a full session", w.Code, w.Body)
+	}
+	stored := &domain.User{}
+	ts.db.First(stored, user.ID)
+	if stored.MustChangePassword {
+		t.Error("the forced change is still pending")
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+)
+
+// In privacy mode the sign-up, password reset and verification endpoints
+// answer the same way whether or not an account exists. Anything that
+// depends on the account is said by email instead.
+
+// reportSignUpConflict tells the owner of a taken email that someone tried
+// to sign up with it, or tells the requester that their username is taken.
+func (s *AuthHandler) reportSignUpConflict(existing []domain.User, email, username string) {
+	for _, other := range existing {
+		if other.Email == email {
+			s.sendAccountMail(
+				email,
+				"Sign up attempt with your email",
+				"Someone tried to create an account with this email. "+
+					"If it was you, sign in or reset your password instead. Otherwise you can ignore this email.",
+			)
+			return
+		}
+	}
+	s.sendAccountMail(
+		email,
+		"Choose another username",
+		fmt.Sprintf("The username %s is already taken, please sign up again with another one.", username),
+	)
+}
+
+// sendAccountMail sends an account email. In privacy mode it is sent in the
+// background so the response time does not depend on whether an email was
+// due, and it always reports success.
+func (s *AuthHandler) sendAccountMail(to, subject, body string) bool {
+	if s.Env.PrivacyMode {
+		go func() {
+			if err := s.mailer.SendMail(s.Env.POSTMARK_FROM_EMAIL, to, subject, body); err != nil {
+				s.L.Print(err)
+			}
+		}()
+		return true
+	}
+	if err := s.mailer.SendMail(s.Env.POSTMARK_FROM_EMAIL, to, subject, body); err != nil {
+		s.L.Print(err)
+		return false
+	}
+	return true
+}
+
+// sendCode issues a code of type ttype to user and emails it, format
+// placing the code in the body. In privacy mode both happen in the
+// background, so a known email takes no longer to answer than an unknown
+// one. Otherwise failures are reported on c and false is returned.
+func (s *AuthHandler) sendCode(
+	c *gin.Context,
+	ttype domain.TokenType,
+	user *domain.User,
+	subject, format string,
+) bool {
+	if s.Env.PrivacyMode {
+		userID, to := user.ID, user.Email
+		go func() {
+			code, err := s.issueCode(ttype, userID)
+			if err != nil {
+				s.L.Print(err)
+				return
+			}
+			err = s.mailer.SendMail(s.Env.POSTMARK_FROM_EMAIL, to, subject, fmt.Sprintf(format, code))
+			if err != nil {
+				s.L.Print(err)
+			}
+		}()
+		return true
+	}
+	code, err := s.issueCode(ttype, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrGenerateToken})
+		return false
+	}
+	if !s.sendAccountMail(user.Email, subject, fmt.Sprintf(format, code)) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrCannotSendMail})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func TestPrivacyModeCodes(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Env.PrivacyMode = true
+	user := ts.createUser(t, "ada", domain.UserRole, "correct horse")
+
+	requests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		request func(email string) (string, interface{})
+		subject string
+		ttype   domain.TokenType
+	}{
+		{
+			name:    "forgot password",
+			handler: ts.ForgotPassword,
+			request: func(email string) (string, interface{}) {
+				return "/auth/password/forgot", gin.H{"email": email}
+			},
+			subject: "Password reset code",
+			ttype:   domain.RESET_PASSWORD,
+		},
+		{
+			name:    "resend verification",
+			handler: ts.ResendVerifyEmail,
+			request: func(email string) (string, interface{}) {
+				return "/auth/email-verify/request?email=" + email, nil
+			},
+			subject: "Email verification code",
+			ttype:   domain.VERIFY_EMAIL,
+		},
+	}
+	for _, tt := range requests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(ts.mailer.Sent())
+			// nothing account specific may happen before the response
+			ts.mailer.gate = make(chan struct{})
+			answer := func(email string) *httptest.ResponseRecorder {
+				path, body := tt.request(email)
+				done := make(chan *httptest.ResponseRecorder)
+				go func() { done <- serve(tt.handler, http.MethodPost, path, body, nil) }()
+				select {
+				case w := <-done:
+					return w
+				case <-time.After(time.Second):
+					t.Fatal("the response waited for the email")
+					return nil
+				}
+			}
+			unknown := answer("nobody@example.com")
+			known := answer(user.Email)
+			close(ts.mailer.gate)
+
+			if known.Code != http.StatusOK || unknown.Code != http.StatusOK {
+				t.Errorf("status known = %d, unknown = %d, want 200", known.Code, unknown.Code)
+			}
+			var knownResp, unknownResp domain.Response
+			decode(t, known, &knownResp)
+			decode(t, unknown, &unknownResp)
+			same := strings.ReplaceAll(knownResp.Message, user.Email, "nobody@example.com")
+			if knownResp.Message == "" || same != unknownResp.Message {
+				t.Errorf("responses differ: %q and %q", knownResp.Message, unknownResp.Message)
+			}
+
+			sent := ts.mailer.Wait(t, before+1)[before:]
+			if len(sent) != 1 || sent[0].To != user.Email || sent[0].Subject != tt.subject {
+				t.Errorf("mail = %+v, want one %q to the account", sent, tt.subject)
+			}
+			var codes int64
+			ts.db.Model(&domain.Token{}).Where("user_id = ? AND type = ?", user.ID, tt.ttype).Count(&codes)
+			if codes != 1 {
+				t.Errorf("%d codes issued, want 1", codes)
+			}
+		})
+	}
+}
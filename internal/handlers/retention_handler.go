@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+// RestoreUser undoes RemoveUser. A user whose identifiers were released
+// needs a new email and username, which may also be given to replace ones
+// someone else has taken since. A new email has to be verified again.
+func (s *UsersHandler) RestoreUser(c *gin.Context) {
+	var details struct {
+		Email    string `json:"email"`
+		Username string `json:"username"`
+	}
+	if c.Request.ContentLength != 0 && c.Bind(&details) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+		return
+	}
+	user, ok := s.deletedUser(c)
+	if !ok {
+		return
+	}
+	// a new email hasn't been shown to belong to the user
+	emailChanged := details.Email != "" && details.Email != user.Email
+	if emailChanged {
+		user.Email = details.Email
+		user.IsEmailVerified = false
+	}
+	if details.Username != "" {
+		user.Username = details.Username
+	}
+	if email, username := services.ReleasedIdentifiers(user.ID); user.Email == email || user.Username == username {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrIdentifiersReleased})
+		return
+	}
+
+	var existing []domain.User
+	err := s.Db.GetClient().Unscoped().
+		Where("id <> ? AND (email = ? OR username = ?)", user.ID, user.Email, user.Username).
+		Find(&existing).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	for _, other := range existing {
+		if other.Username == user.Username {
+			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrExistingUsername})
+			return
+		}
+	}
+	if len(existing) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrExistingEmail})
+		return
+	}
+
+	err = s.Db.GetClient().Unscoped().Model(user).Updates(map[string]interface{}{
+		"email":             user.Email,
+		"username":          user.Username,
+		"is_email_verified": user.IsEmailVerified,
+		"deleted_at":        nil,
+	}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+	audit(c, s.audit, s.L, "user.restore", "user", user.ID, &details)
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    user,
+	})
+}
+
+// ReleaseIdentifiers frees a deleted user's email and username for other
+// accounts before the user is purged.
+func (s *UsersHandler) ReleaseIdentifiers(c *gin.Context) {
+	user, ok := s.deletedUser(c)
+	if !ok {
+		return
+	}
+	if err := s.retention.ReleaseIdentifiers(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	// the point is to forget them, the log doesn't keep them either
+	audit(c, s.audit, s.L, "user.release_identifiers", "user", user.ID, nil)
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    user,
+	})
+}
+
+func (s *UsersHandler) deletedUser(c *gin.Context) (*domain.User, bool) {
+	id, ok := util.GetIDParam(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("deleted user")})
+		return nil, false
+	}
+	user := &domain.User{}
+	err := s.Db.GetClient().Unscoped().
+		Where("deleted_at IS NOT NULL AND anonymized_at IS NULL").
+		First(user, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("deleted user")})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return nil, false
+	}
+	return user, true
+}
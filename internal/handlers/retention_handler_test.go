@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+)
+
+func TestRestoreUser(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.createUser(t, "root", domain.AdminRole, "correct horse")
+	param := func(user *domain.User) gin.Param {
+		return gin.Param{Key: "id", Value: strconv.FormatUint(uint64(user.ID), 10)}
+	}
+	deleted := func(username string) *domain.User {
+		user := ts.createUser(t, username, domain.UserRole, "correct horse")
+		ts.db.Model(user).Updates(map[string]interface{}{"is_email_verified": true})
+		ts.db.Delete(user)
+		return user
+	}
+	restore := func(user *domain.User, body gin.H) (int, string, *domain.User) {
+		w := serve(ts.usersHandler().RestoreUser, http.MethodPost, "/", body, payloadFor(admin), param(user))
+		var resp struct{ Error string }
+		decode(t, w, &resp)
+		stored := &domain.User{}
+		ts.db.Unscoped().First(stored, user.ID)
+		return w.Code, resp.Error, stored
+	}
+
+	ada := deleted("ada")
+	if code, _, stored := restore(ada, nil); code != http.StatusOK || stored.DeletedAt.Valid || !stored.IsEmailVerified {
+		t.Errorf("restore = %d, %+v, want ada back and still verified", code, stored)
+	}
+	if code, _, _ := restore(ada, nil); code != http.StatusNotFound {
+		t.Errorf("restoring an active user = %d, want 404", code)
+	}
+	w := serve(ts.usersHandler().RestoreUser, http.MethodPost, "/", nil, payloadFor(admin),
+		gin.Param{Key: "id", Value: "0 OR deleted_at IS NOT NULL"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("restoring with an id that isn't a number = %d, want 404", w.Code)
+	}
+
+	bob := deleted("bob")
+	if code, _, stored := restore(bob, gin.H{"email": "bob@example.com"}); code != http.StatusOK || !stored.IsEmailVerified {
+		t.Errorf("restore with the same email = %d, %+v, want it still verified", code, stored)
+	}
+
+	cy := deleted("cy")
+	w = serve(ts.usersHandler().ReleaseIdentifiers, http.MethodPost, "/", nil, payloadFor(admin), param(cy))
+	if w.Code != http.StatusOK {
+		t.Fatalf("release = %d %s", w.Code, w.Body)
+	}
+	var entry domain.AuditLog
+	ts.db.Where("action = ?", "user.release_identifiers").First(&entry)
+	if strings.Contains(entry.Details, "cy") {
+		t.Errorf("audit log kept the released identifiers: %q", entry.Details)
+	}
+	if code, msg, _ := restore(cy, nil); code != http.StatusBadRequest || msg != helper.ErrIdentifiersReleased {
+		t.Errorf("restore after release = %d %q", code, msg)
+	}
+	if code, msg, _ := restore(cy, gin.H{"email": "ada@example.com", "username": "cy"}); code != http.StatusBadRequest ||
+		msg != helper.ErrExistingEmail {
+		t.Errorf("restore with a taken email = %d %q", code, msg)
+	}
+	code, _, stored := restore(cy, gin.H{"email": "cy@new.example.com", "username": "cy"})
+	if code != http.StatusOK || stored.Email != "cy@new.example.com" || stored.IsEmailVerified {
+		t.Errorf("restore with a new email = %d, %+v, want it unverified", code, stored)
+	}
+	if email, _ := services.ReleasedIdentifiers(cy.ID); stored.Email == email {
+		t.Error("restore kept the placeholder email")
+	}
+}
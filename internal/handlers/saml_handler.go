@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+
+	"github.com/crewjam/saml"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+const samlRequestCookie = "saml_request_id"
+
+type SAMLHandler struct {
+	*AuthHandler
+	saml  services.SAMLService
+	audit services.AuditService
+}
+
+func NewSAMLHandler(
+	ah *AuthHandler,
+	samlService services.SAMLService,
+	audit services.AuditService,
+) *SAMLHandler {
+	return &SAMLHandler{AuthHandler: ah, saml: samlService, audit: audit}
+}
+
+func (s *SAMLHandler) Metadata(c *gin.Context) {
+	sp, ok := s.serviceProvider(c)
+	if !ok {
+		return
+	}
+	buf, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	c.Data(http.StatusOK, "application/samlmetadata+xml", buf)
+}
+
+// Login starts SP-initiated SSO. The request ID is kept in a short lived
+// cookie so the ACS can match the IdP's InResponseTo.
+func (s *SAMLHandler) Login(c *gin.Context) {
+	sp, ok := s.serviceProvider(c)
+	if !ok {
+		return
+	}
+	req, err := sp.MakeAuthenticationRequest(
+		sp.GetSSOBindingLocation(saml.HTTPRedirectBinding),
+		saml.HTTPRedirectBinding,
+		saml.HTTPPostBinding,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	redirect, err := req.Redirect(c.Query("RelayState"), sp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.SetSameSite(http.SameSiteNoneMode)
+	c.SetCookie(samlRequestCookie, req.ID, int(saml.MaxIssueDelay.Seconds()), sp.AcsURL.Path, "", true, true)
+	c.Redirect(http.StatusFound, redirect.String())
+}
+
+// ACS consumes the IdP's signed response and signs the asserted user in.
+func (s *SAMLHandler) ACS(c *gin.Context) {
+	conn, sp, ok := s.connection(c)
+	if !ok {
+		return
+	}
+	// ParseResponse reads the form, it doesn't parse it
+	if err := c.Request.ParseForm(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidSAMLResponse})
+		return
+	}
+	var requestIDs []string
+	if id, err := c.Cookie(samlRequestCookie); err == nil && id != "" {
+		requestIDs = append(requestIDs, id)
+	}
+	assertion, err := sp.ParseResponse(c.Request, requestIDs)
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			s.L.Printf("saml %s: %v", conn.Slug, invalid.PrivateErr)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": helper.ErrInvalidSAMLResponse})
+		return
+	}
+	c.SetCookie(samlRequestCookie, "", -1, sp.AcsURL.Path, "", true, true)
+
+	user, err := s.saml.Provision(conn, assertion)
+	if err != nil {
+		s.L.Printf("saml %s: %v", conn.Slug, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": helper.ErrInvalidSAMLResponse})
+		return
+	}
+	// the IdP vouches for the user, but the account may still be barred here
+	locked, err := s.lockout.Locked(user.Email)
+	if err != nil {
+		s.L.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	if locked {
+		s.recordLogin(c, user.Email, user, services.SAMLProvider, domain.LoginLocked)
+		c.JSON(http.StatusForbidden, gin.H{"error": helper.ErrAccountLocked})
+		return
+	}
+	if refused, ok := s.admit(c, user); !ok {
+		s.recordLogin(c, user.Email, user, services.SAMLProvider, refused)
+		return
+	}
+	accessToken, refreshToken, err := login(user, s.AuthHandler)
+	if err != nil {
+		s.recordLogin(c, user.Email, user, services.SAMLProvider, domain.LoginFailed)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.recordLogin(c, user.Email, user, services.SAMLProvider, domain.LoginSucceeded)
+
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data: LoginResponse{
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+		},
+	})
+}
+
+func (s *SAMLHandler) GetConnections(c *gin.Context) {
+	var conns []domain.SAMLConnection
+	if err := s.Db.GetClient().Find(&conns).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailGet("saml connections")})
+		return
+	}
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    &conns,
+	})
+}
+
+func (s *SAMLHandler) CreateConnection(c *gin.Context) {
+	conn := domain.SAMLConnection{}
+	if c.Bind(&conn) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+		return
+	}
+	conn.ID = 0
+	if conn.Slug == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "slug is required"})
+		return
+	}
+	if err := s.saml.ValidateConnection(&conn); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.Db.GetClient().Create(&conn).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailCreate("saml connection")})
+		return
+	}
+	c.JSON(http.StatusCreated, domain.Response{
+		Message: helper.Success,
+		Data:    &conn,
+	})
+}
+
+func (s *SAMLHandler) UpdateConnection(c *gin.Context) {
+	id, ok := util.GetIDParam(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("saml connection")})
+		return
+	}
+	conn := domain.SAMLConnection{}
+	if err := s.Db.GetClient().First(&conn, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("saml connection")})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	if c.Bind(&conn) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+		return
+	}
+	conn.ID = id
+	if err := s.saml.ValidateConnection(&conn); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.Db.GetClient().Save(&conn).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    &conn,
+	})
+}
+
+func (s *SAMLHandler) RemoveConnection(c *gin.Context) {
+	id, ok := util.GetIDParam(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("saml connection")})
+		return
+	}
+	if err := s.Db.GetClient().Delete(&domain.SAMLConnection{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailDelete("saml connection")})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": helper.Success})
+}
+
+// LinkUser lets a connection sign in an existing account. Without this
+// step a connection only signs in the accounts it created itself.
+func (s *SAMLHandler) LinkUser(c *gin.Context) {
+	var details struct {
+		ConnectionID uint `json:"connection_id"`
+	}
+	if c.Bind(&details) != nil || details.ConnectionID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+		return
+	}
+	id, ok := util.GetIDParam(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("user")})
+		return
+	}
+	user := &domain.User{}
+	if err := s.Db.GetClient().First(user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("user")})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	conn := &domain.SAMLConnection{}
+	if err := s.Db.GetClient().First(conn, details.ConnectionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("saml connection")})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	if err := s.saml.Link(conn, user); err != nil {
+		s.L.Printf("saml %s: %v", conn.Slug, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrSAMLLinkRefused})
+		return
+	}
+	audit(c, s.audit, s.L, "user.saml_link", "user", user.ID, &details)
+	c.JSON(http.StatusOK, domain.Response{Message: helper.Success})
+}
+
+func (s *SAMLHandler) serviceProvider(c *gin.Context) (*saml.ServiceProvider, bool) {
+	_, sp, ok := s.connection(c)
+	return sp, ok
+}
+
+func (s *SAMLHandler) connection(
+	c *gin.Context,
+) (*domain.SAMLConnection, *saml.ServiceProvider, bool) {
+	conn := &domain.SAMLConnection{}
+	err := s.Db.GetClient().
+		Where("slug = ? AND enabled = ?", c.Param("slug"), true).
+		First(conn).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": helper.ErrUnknownSAMLConnection})
+			return nil, nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return nil, nil, false
+	}
+	sp, err := s.saml.ServiceProvider(conn)
+	if err != nil {
+		if errors.Is(err, services.ErrSAMLNotConfigured) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": helper.ErrSAMLNotConfigured})
+			return nil, nil, false
+		}
+		s.L.Printf("saml %s: %v", conn.Slug, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return nil, nil, false
+	}
+	return conn, sp, true
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/services"
+)
+
+func TestRemoveConnection(t *testing.T) {
+	ts := newTestServer(t)
+	h := NewSAMLHandler(ts.AuthHandler, nil, services.NewAuditService(ts.db))
+	acme := &domain.SAMLConnection{Slug: "acme", IDPMetadataXML: "<x/>"}
+	globex := &domain.SAMLConnection{Slug: "globex", IDPMetadataXML: "<x/>"}
+	for _, conn := range []*domain.SAMLConnection{acme, globex} {
+		if err := ts.db.Create(conn).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	remove := func(id string) int {
+		return serve(h.RemoveConnection, http.MethodDelete, "/admin/saml/connections/"+url.PathEscape(id), nil, nil,
+			gin.Param{Key: "id", Value: id}).Code
+	}
+
+	if got := remove("0 OR 1=1"); got != http.StatusNotFound {
+		t.Errorf("removing with an id that isn't a number = %d, want 404", got)
+	}
+	var left int64
+	if ts.db.Model(&domain.SAMLConnection{}).Count(&left); left != 2 {
+		t.Fatalf("%d connections left, want both", left)
+	}
+	if got := remove(strconv.FormatUint(uint64(acme.ID), 10)); got != http.StatusOK {
+		t.Errorf("remove = %d, want 200", got)
+	}
+	if ts.db.First(&domain.SAMLConnection{}, globex.ID).Error != nil {
+		t.Error("removing acme removed globex")
+	}
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+// SuspendUser blocks the user from signing in and from using the tokens
+// they already hold. Without an until time the suspension lasts until
+// lifted.
+func (s *UsersHandler) SuspendUser(c *gin.Context) {
+	var details struct {
+		Reason string     `json:"reason"`
+		Until  *time.Time `json:"until"`
+	}
+	if c.Bind(&details) != nil || details.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+		return
+	}
+	if details.Until != nil && !details.Until.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrSuspensionEnded})
+		return
+	}
+	payload, err := util.GetPayload(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrFailParsePayload})
+		return
+	}
+	user, ok := s.user(c)
+	if !ok {
+		return
+	}
+	if user.ID == payload.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrSuspendSelf})
+		return
+	}
+	if err := s.suspensions.Suspend(user, details.Reason, details.Until, payload.ID); err != nil {
+		if errors.Is(err, services.ErrLastAdmin) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrLastAdminSuspension})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	audit(c, s.audit, s.L, "user.suspend", "user", user.ID, &details)
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    user,
+	})
+}
+
+func (s *UsersHandler) UnsuspendUser(c *gin.Context) {
+	user, ok := s.user(c)
+	if !ok {
+		return
+	}
+	if err := s.suspensions.Unsuspend(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	audit(c, s.audit, s.L, "user.unsuspend", "user", user.ID, nil)
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    user,
+	})
+}
+
+// user loads the user named by the id parameter.
+func (s *UsersHandler) user(c *gin.Context) (*domain.User, bool) {
+	id, ok := util.GetIDParam(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("user")})
+		return nil, false
+	}
+	user := &domain.User{}
+	if err := s.Db.GetClient().First(user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": helper.NotFound("user")})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return nil, false
+	}
+	return user, true
+}
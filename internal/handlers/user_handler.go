@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,33 +12,157 @@ import (
 
 	"github.com/ostheperson/go-auth-service/internal/domain"
 	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
 	"github.com/ostheperson/go-auth-service/internal/util"
 )
 
 type UsersHandler struct {
 	*domain.Server
+	suspensions services.SuspensionService
+	importer    services.UserImporter
+	exporter    services.UserExporter
+	retention   services.UserRetentionService
+	audit       services.AuditService
+	admins      services.AdminGuard
 }
 
-func NewUsersHandler(s *domain.Server) *UsersHandler {
-	return &UsersHandler{Server: s}
+func NewUsersHandler(
+	s *domain.Server,
+	suspensions services.SuspensionService,
+	importer services.UserImporter,
+	exporter services.UserExporter,
+	retention services.UserRetentionService,
+	audit services.AuditService,
+	admins services.AdminGuard,
+) *UsersHandler {
+	return &UsersHandler{
+		Server:      s,
+		suspensions: suspensions,
+		importer:    importer,
+		exporter:    exporter,
+		retention:   retention,
+		audit:       audit,
+		admins:      admins,
+	}
 }
 
+// GetUsers lists users matching the filters in the query string, see
+// userFilter, with the total number of matches. Pages are selected with the
+// cursor parameter, or with page for older clients.
 func (s *UsersHandler) GetUsers(c *gin.Context) {
 	var users []domain.User
 	limit, page := util.GetPaginationParams(c)
+	cursor, err := util.GetCursorParam(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := userFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	order, err := filter.Order()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidFilter + ": " + err.Error()})
+		return
+	}
+	query, err := filter.Apply(s.Db.GetClient().Model(&domain.User{}))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidFilter + ": " + err.Error()})
+		return
+	}
+	// the query is used twice, for the count and the page
+	query = query.Session(&gorm.Session{})
 
-	// TODO: Add filtering options
-	if err := s.Db.GetClient().Model(&users).Limit(limit).Offset((page - 1) * limit).Find(&users).Error; err != nil {
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailGet("users")})
 		return
 	}
+	meta := &domain.PageMeta{Total: total, Limit: limit}
+	if cursor != nil {
+		query, err = filter.Seek(query, cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// one extra row tells whether there is another page
+		if err := query.Limit(limit + 1).Find(&users).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailGet("users")})
+			return
+		}
+		users, meta.NextCursor, meta.PrevCursor = util.KeysetPage(users, limit, cursor, filter.Cursor)
+	} else {
+		offset := (page - 1) * limit
+		if err := query.Order(order).Limit(limit).Offset(offset).Find(&users).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": helper.FailGet("users")})
+			return
+		}
+		meta.Page = page
+		if len(users) > 0 {
+			if int64(offset+len(users)) < total {
+				meta.NextCursor = filter.Cursor(&users[len(users)-1], false).Encode()
+			}
+			if page > 1 {
+				meta.PrevCursor = filter.Cursor(&users[0], true).Encode()
+			}
+		}
+	}
 
 	c.JSON(http.StatusOK, domain.Response{
 		Message: helper.Success,
 		Data:    &users,
+		Meta:    meta,
 	})
 }
 
+// userFilter reads role, email_verified, created_after, created_before,
+// last_login_after, last_login_before, state, q and sort from the query
+// string. Dates are RFC 3339 timestamps or plain dates.
+func userFilter(c *gin.Context) (services.UserFilter, error) {
+	filter := services.UserFilter{
+		Role:  domain.Role(c.Query("role")),
+		State: c.Query("state"),
+		Query: c.Query("q"),
+		Sort:  c.Query("sort"),
+	}
+	if _, known := domain.Roles[filter.Role]; filter.Role != "" && !known {
+		return filter, errors.New(helper.ErrInvalidRole)
+	}
+	if v := c.Query("email_verified"); v != "" {
+		verified, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("%s: email_verified", helper.ErrInvalidFilter)
+		}
+		filter.EmailVerified = &verified
+	}
+	dates := []struct {
+		param string
+		dst   **time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+		{"last_login_after", &filter.LastLoginAfter},
+		{"last_login_before", &filter.LastLoginBefore},
+	}
+	for _, d := range dates {
+		v := c.Query(d.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, v)
+		}
+		if err != nil {
+			return filter, fmt.Errorf("%s: %s", helper.ErrInvalidFilter, d.param)
+		}
+		*d.dst = &t
+	}
+	return filter, nil
+}
+
 func (s *UsersHandler) GetUser(c *gin.Context) {
 	payload, err := util.GetPayload(c)
 	if err != nil {
@@ -115,15 +240,121 @@ func (s *UsersHandler) UpdateUser(c *gin.Context) {
 	})
 }
 
+// RemoveUser soft-deletes the user and ends their sessions. The user can
+// be restored until the retention period is over.
 func (s *UsersHandler) RemoveUser(c *gin.Context) {
-	id := c.Param("id")
-	if err := s.Db.GetClient().Model(&domain.User{}).Where("id = ?", id).Update("deleted_at", time.Now()).Error; err != nil {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(404, gin.H{"error": helper.NotFound("user")})
+		return
+	}
+	id := uint(userID)
+	err = s.Db.GetClient().Transaction(func(tx *gorm.DB) error {
+		if err := s.admins.Check(tx, id); err != nil {
+			return err
+		}
+		if err := tx.Model(&domain.User{}).Where("id = ?", id).Update("deleted_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND type = ?", id, domain.REFRESH).Delete(&domain.Token{}).Error
+	})
+	if errors.Is(err, services.ErrLastAdmin) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrLastAdminRemoval})
+		return
+	}
+	if err != nil {
 		c.JSON(500, gin.H{"error": helper.FailDelete("users")})
 		return
 	}
+	audit(c, s.audit, s.L, "user.delete", "user", id, nil)
 	c.JSON(200, gin.H{"message": helper.Success})
 }
 
+// ForcePasswordChange makes the user pick a new password at next sign-in
+// and ends their current sessions.
+func (s *UsersHandler) ForcePasswordChange(c *gin.Context) {
+	id, ok := util.GetIDParam(c)
+	if !ok {
+		c.JSON(404, gin.H{"error": helper.NotFound("user")})
+		return
+	}
+	user := domain.User{}
+	if err := s.Db.GetClient().First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(404, gin.H{"error": helper.NotFound("user")})
+			return
+		}
+		c.JSON(500, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	err := s.Db.GetClient().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("must_change_password", true).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND type = ?", user.ID, domain.REFRESH).
+			Delete(&domain.Token{}).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    &user,
+	})
+}
+
+// ChangeRole sets the user's role and ends their sessions so tokens carrying
+// the old role stop working. The last admin can't be demoted.
+func (s *UsersHandler) ChangeRole(c *gin.Context) {
+	var details struct {
+		Role domain.Role `json:"role"`
+	}
+	if c.Bind(&details) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrFailedReadBody})
+		return
+	}
+	if _, known := domain.Roles[details.Role]; !known {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidRole})
+		return
+	}
+	user, ok := s.user(c)
+	if !ok {
+		return
+	}
+	previous := user.Role
+	if previous == details.Role {
+		c.JSON(http.StatusOK, domain.Response{Message: helper.Success, Data: user})
+		return
+	}
+	err := s.Db.GetClient().Transaction(func(tx *gorm.DB) error {
+		if err := s.admins.Check(tx, user.ID); err != nil {
+			return err
+		}
+		if err := tx.Model(user).Update("role", details.Role).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND type = ?", user.ID, domain.REFRESH).
+			Delete(&domain.Token{}).Error
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrLastAdmin) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrLastAdmin})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+		return
+	}
+	audit(c, s.audit, s.L, "user.role_change", "user", user.ID, gin.H{
+		"before": previous,
+		"after":  details.Role,
+	})
+	c.JSON(http.StatusOK, domain.Response{
+		Message: helper.Success,
+		Data:    user,
+	})
+}
+
 func (s *UsersHandler) ClearTable(c *gin.Context) {
 	if err := s.Db.GetClient().Delete(&domain.User{}).Error; err != nil {
 		panic("failed to clear table")
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+)
+
+func TestLastAdmin(t *testing.T) {
+	ts := newTestServer(t)
+	root := ts.createUser(t, "root", domain.AdminRole, "correct horse")
+	ada := ts.createUser(t, "ada", domain.AdminRole, "correct horse")
+	bob := ts.createUser(t, "bob", domain.AdminRole, "correct horse")
+	param := func(user *domain.User) gin.Param {
+		return gin.Param{Key: "id", Value: strconv.FormatUint(uint64(user.ID), 10)}
+	}
+	changeRole := func(user *domain.User, role domain.Role) (int, string) {
+		w := serve(ts.usersHandler().ChangeRole, http.MethodPut, "/", gin.H{"role": role}, payloadFor(root), param(user))
+		var resp struct{ Error string }
+		decode(t, w, &resp)
+		return w.Code, resp.Error
+	}
+	remove := func(user *domain.User) (int, string) {
+		w := serve(ts.usersHandler().RemoveUser, http.MethodDelete, "/", nil, payloadFor(root), param(user))
+		var resp struct{ Error string }
+		decode(t, w, &resp)
+		return w.Code, resp.Error
+	}
+	deleteAccount := func(user *domain.User) (int, string) {
+		w := serve(ts.DeleteAccount, http.MethodDelete, "/users/me", gin.H{"password": "correct horse"}, payloadFor(user))
+		var resp struct{ Error string }
+		decode(t, w, &resp)
+		return w.Code, resp.Error
+	}
+
+	// three admins: one leaves, one is removed, and the last has to stay
+	if code, _ := deleteAccount(ada); code != http.StatusAccepted {
+		t.Fatalf("deleting the account of one of three admins = %d", code)
+	}
+	if code, _ := remove(bob); code != http.StatusOK {
+		t.Fatalf("removing one of two admins = %d", code)
+	}
+	if code, msg := changeRole(root, domain.UserRole); code != http.StatusBadRequest || msg != helper.ErrLastAdmin {
+		t.Errorf("demoting the last admin = %d %q", code, msg)
+	}
+	if code, msg := remove(root); code != http.StatusBadRequest || msg != helper.ErrLastAdminRemoval {
+		t.Errorf("removing the last admin = %d %q", code, msg)
+	}
+	if code, msg := deleteAccount(root); code != http.StatusBadRequest || msg != helper.ErrLastAdminDeletion {
+		t.Errorf("deleting the account of the last admin = %d %q", code, msg)
+	}
+	stored := &domain.User{}
+	if err := ts.db.First(stored, root.ID).Error; err != nil || stored.Role != domain.AdminRole || stored.DeletionScheduledAt != nil {
+		t.Errorf("last admin is now %+v, %v", stored, err)
+	}
+
+	// with another admin the first can go
+	cy := ts.createUser(t, "cy", domain.UserRole, "correct horse")
+	if code, _ := changeRole(cy, domain.AdminRole); code != http.StatusOK {
+		t.Fatalf("promoting = %d", code)
+	}
+	if code, _ := changeRole(root, domain.UserRole); code != http.StatusOK {
+		t.Errorf("demoting one of two admins = %d", code)
+	}
+
+	// suspending takes the last admin's access as surely as demoting
+	suspend := func(id string) (int, string) {
+		w := serve(ts.usersHandler().SuspendUser, http.MethodPost, "/", gin.H{"reason": "spam"}, payloadFor(root),
+			gin.Param{Key: "id", Value: id})
+		var resp struct{ Error string }
+		decode(t, w, &resp)
+		return w.Code, resp.Error
+	}
+	if code, msg := suspend(param(cy).Value); code != http.StatusBadRequest || msg != helper.ErrLastAdminSuspension {
+		t.Errorf("suspending the last admin = %d %q", code, msg)
+	}
+	if code, _ := suspend("0 OR role = 'admin'"); code != http.StatusNotFound {
+		t.Errorf("suspending with an id that isn't a number = %d, want 404", code)
+	}
+	if ts.db.First(stored, cy.ID); stored.SuspendedAt != nil {
+		t.Errorf("last admin is suspended: %+v", stored)
+	}
+
+	// a suspended admin can't administer, so doesn't count as one left
+	if code, _ := changeRole(root, domain.AdminRole); code != http.StatusOK {
+		t.Fatalf("promoting = %d", code)
+	}
+	if code, _ := suspend(param(cy).Value); code != http.StatusOK {
+		t.Fatalf("suspending one of two admins = %d", code)
+	}
+	if code, msg := changeRole(root, domain.UserRole); code != http.StatusBadRequest || msg != helper.ErrLastAdmin {
+		t.Errorf("demoting the last admin next to a suspended one = %d %q", code, msg)
+	}
+}
@@ -12,10 +12,38 @@ const (
 	ErrFailParsePayload = "failed to get logged in details"
 
 	// Auth
-	ErrFailHash           = "Failed to hash password"
-	ErrUnauthorized       = "Action not allowed"
-	ErrInvalidCredentials = "Invalid details"
-	ErrExpiredAuthToken   = "Expired Token"
+	ErrFailHash               = "Failed to hash password"
+	ErrUnauthorized           = "Action not allowed"
+	ErrInvalidCredentials     = "Invalid details"
+	ErrExpiredAuthToken       = "Expired Token"
+	ErrWeakPassword           = "Password does not meet the password policy"
+	ErrPasswordReused         = "Password was used recently, choose another"
+	ErrPasswordChangeRequired = "Password change required"
+	ErrPasswordChangeToken    = "Token may only be used to change password"
+	ErrSessionRevoked         = "Session has been revoked"
+	ErrExternalPassword       = "Password is managed by your identity provider"
+	ErrTooManyRequests        = "Too many requests, try again later"
+	ErrChallengeRequired      = "Challenge required"
+	ErrNetworkNotAllowed      = "Access from this network is not allowed"
+	ErrNetworkSelfLockout     = "Change would block your own network"
+	ErrInvalidNetworkAction   = "Action must be allow or deny"
+	ErrInvalidRole            = "Unknown role"
+	ErrAccountSuspended       = "Account is suspended"
+	ErrAccountLocked          = "Account is locked, try again later"
+	ErrSuspendSelf            = "You can't suspend your own account"
+	ErrSuspensionEnded        = "Suspension end must be in the future"
+	ErrLastAdmin              = "The last admin can't be demoted"
+	ErrLastAdminDeletion      = "The last admin can't delete their account"
+	ErrLastAdminRemoval       = "The last admin can't be removed"
+	ErrLastAdminSuspension    = "The last admin can't be suspended"
+	ErrLastAdminProvider      = "Your directory no longer makes you an admin, but you are the last admin here"
+	ErrReauthRequired         = "Sign in again to continue"
+	ErrDeletionScheduled      = "Account is scheduled for deletion, use the link in the email to cancel"
+	DeletionScheduled         = "Account scheduled for deletion"
+	ErrChallengesDisabled     = "Challenges are disabled"
+	UnlockEmailSent           = "If the account is locked, an unlock code has been sent to its email"
+	SignUpAccepted            = "Sign up received, check your email"
+	InviteSent                = "Invite sent"
 
 	// Token
 	ErrGenerateToken       = "Cannot create confrimation code"
@@ -23,11 +51,29 @@ const (
 	ErrInvalidRefreshToken = "Invalid refresh token"
 	ErrExpiredCode         = "Expired code"
 
+	// Token exchange
+	ErrUnsupportedGrantType = "Unsupported grant type"
+	ErrInvalidClient        = "Client authentication failed"
+	ErrUnsupportedTokenType = "Unsupported token type"
+	ErrInvalidSubjectToken  = "Invalid subject token"
+	ErrInvalidActorToken    = "Invalid actor token"
+	ErrInvalidAudience      = "Audience not allowed"
+	ErrInvalidScope         = "Requested scope exceeds subject token scope"
+	ErrForeignAudience      = "Token was issued for another audience"
+
+	// SAML
+	ErrUnknownSAMLConnection = "Unknown SAML connection"
+	ErrInvalidSAMLResponse   = "Invalid SAML response"
+	ErrSAMLNotConfigured     = "SAML is not configured"
+	ErrSAMLLinkRefused       = "The account can't be linked to this SAML connection"
+
 	// User
-	ErrExistingUsername   = "Existing username"
-	ErrExistingEmail      = "Existing email"
-	ErrNoExistingUsername = "No account with this username"
-	ErrNoExistingEmail    = "No account with this email"
+	ErrExistingUsername    = "Existing username"
+	ErrExistingEmail       = "Existing email"
+	ErrNoExistingUsername  = "No account with this username"
+	ErrNoExistingEmail     = "No account with this email"
+	ErrInvalidFilter       = "Invalid filter"
+	ErrIdentifiersReleased = "Email and username were released, choose new ones"
 
 	// Mailer
 	ErrCannotSendMail = "cannot send emails at the moment"
@@ -9,6 +9,7 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/services"
 )
 
 type JobService interface {
@@ -18,16 +19,20 @@ type JobService interface {
 type jobService struct {
 	cron *cron.Cron
 	db   *gorm.DB
+	env  *domain.Env
 	l    *log.Logger
 }
 
-func NewJobService(tz string, db *gorm.DB) JobService {
+func NewJobService(env *domain.Env, db *gorm.DB) JobService {
 	l := log.New(os.Stdout, "jobs", log.LstdFlags)
-	loc, err := time.LoadLocation(tz)
+	loc, err := time.LoadLocation(env.Timezone)
 	if err != nil {
 		panic(err)
 	}
-	return &jobService{cron: cron.New(cron.WithLocation(loc)), l: l, db: db}
+	if err := services.CheckDeletionMode(env.AccountDeletionMode); err != nil {
+		panic(err)
+	}
+	return &jobService{cron: cron.New(cron.WithLocation(loc)), l: l, db: db, env: env}
 }
 
 func (js *jobService) Start() {
@@ -42,5 +47,56 @@ func (js *jobService) scheduleJobs() {
 		js.db.Where("expires_at < ?", time.Now()).Delete(&domain.Token{})
 	})
 
+	// remove rate limit buckets that have refilled
+	js.cron.AddFunc("@every 5m", func() {
+		js.db.Where("expires_at < ?", time.Now()).Delete(&domain.RateLimitBucket{})
+	})
+
+	// lift suspensions that have run out
+	suspensions := services.NewSuspensionService(js.db)
+	js.cron.AddFunc("@every 1m", func() {
+		if n, err := suspensions.LiftExpired(); err != nil {
+			js.l.Print(err)
+		} else if n > 0 {
+			js.l.Printf("lifted %d expired suspensions", n)
+		}
+	})
+
+	// purge users deleted longer ago than the retention period
+	if js.env.UserRetentionDays > 0 {
+		retention := services.NewUserRetentionService(js.db)
+		js.cron.AddFunc("@hourly", func() {
+			before := time.Now().AddDate(0, 0, -int(js.env.UserRetentionDays))
+			if n, err := retention.PurgeDeleted(before); err != nil {
+				js.l.Print(err)
+			} else if n > 0 {
+				js.l.Printf("purged %d deleted users", n)
+			}
+		})
+	}
+
+	// forget sign-in attempts older than the login history retention period
+	if js.env.LoginHistoryRetentionDays > 0 {
+		logins := services.NewLoginHistoryService(js.db)
+		js.cron.AddFunc("@hourly", func() {
+			before := time.Now().AddDate(0, 0, -int(js.env.LoginHistoryRetentionDays))
+			if n, err := logins.Purge(before); err != nil {
+				js.l.Print(err)
+			} else if n > 0 {
+				js.l.Printf("purged %d sign-in attempts", n)
+			}
+		})
+	}
+
+	// carry out account deletions whose grace period has ended
+	deletions := services.NewUserRetentionService(js.db)
+	js.cron.AddFunc("@every 10m", func() {
+		if n, err := deletions.DeleteScheduled(time.Now(), js.env.AccountDeletionMode); err != nil {
+			js.l.Print(err)
+		} else if n > 0 {
+			js.l.Printf("deleted %d accounts at their owners' request", n)
+		}
+	})
+
 	// TODO: add job to delete pending payment links
 }
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+const challengeResponseHeader = "X-Challenge-Response"
+
+// ChallengeMiddleware asks risky clients to solve a challenge before the
+// request is handled. A client is risky when its IP sends more requests to a
+// route than rate allows, or when requests from its IP or for the account it
+// names were refused for wrong credentials more often than failures allows.
+// The solution is sent in the X-Challenge-Response header. A nil verifier
+// disables challenges.
+func ChallengeMiddleware(
+	verifier services.ChallengeVerifier,
+	store services.RateLimitStore,
+	l *log.Logger,
+	rate services.RateLimit,
+	failures services.RateLimit,
+) gin.HandlerFunc {
+	if verifier == nil {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		failureKeys := []string{"challenge:failures:ip:" + ip}
+		if account := accountIdentifier(c); account != "" {
+			failureKeys = append(failureKeys, "challenge:failures:account:"+account)
+		}
+
+		risky := false
+		if rate.Burst > 0 {
+			result, err := store.Take("challenge:rate:"+c.FullPath()+":"+ip, rate)
+			if err != nil {
+				l.Printf("challenge: %v", err)
+			}
+			risky = err == nil && !result.Allowed
+		}
+		for _, key := range failureKeys {
+			if failures.Burst <= 0 || risky {
+				break
+			}
+			result, err := store.Peek(key, failures)
+			if err != nil {
+				l.Printf("challenge: %v", err)
+				continue
+			}
+			risky = !result.Allowed
+		}
+
+		if risky {
+			err := verifier.Verify(c.GetHeader(challengeResponseHeader), ip)
+			if err != nil {
+				if !errors.Is(err, services.ErrChallengeFailed) {
+					l.Printf("challenge: %v", err)
+				}
+				challenge, err := verifier.Issue()
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusPreconditionRequired, gin.H{
+					"error":     helper.ErrChallengeRequired,
+					"challenge": challenge,
+				})
+				return
+			}
+		}
+
+		c.Next()
+
+		if failures.Burst <= 0 || !util.IsCredentialFailure(c) {
+			return
+		}
+		for _, key := range failureKeys {
+			if _, err := store.Take(key, failures); err != nil {
+				l.Printf("challenge: %v", err)
+			}
+		}
+	}
+}
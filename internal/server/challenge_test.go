@@ -0,0 +1,67 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+func TestChallengeMiddlewareCountsCredentialFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/auth/user/signin", ChallengeMiddleware(
+		services.NewStubChallengeVerifier("pass"),
+		services.NewMemoryRateLimitStore(),
+		log.New(io.Discard, "", 0),
+		services.RateLimit{},
+		services.RateLimit{Burst: 2, Window: time.Minute},
+	), func(c *gin.Context) {
+		switch c.Query("result") {
+		case "wrong-password":
+			util.MarkCredentialFailure(c)
+			c.Status(http.StatusUnauthorized)
+		case "bad-request":
+			c.Status(http.StatusBadRequest)
+		case "suspended":
+			c.Status(http.StatusForbidden)
+		default:
+			c.Status(http.StatusOK)
+		}
+	})
+	request := func(result, email, answer string) int {
+		body := strings.NewReader(`{"email":"` + email + `"}`)
+		req := httptest.NewRequest(http.MethodPost, "/auth/user/signin?result="+result, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(challengeResponseHeader, answer)
+		req.RemoteAddr = "203.0.113.7:5000"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for i := 0; i < 5; i++ {
+		request("bad-request", "ada@example.com", "")
+		request("suspended", "ada@example.com", "")
+	}
+	if code := request("ok", "ada@example.com", ""); code != http.StatusOK {
+		t.Fatalf("after refusals that weren't about credentials = %d, want no challenge", code)
+	}
+
+	request("wrong-password", "ada@example.com", "")
+	request("wrong-password", "ada@example.com", "")
+	if code := request("ok", "ada@example.com", ""); code != http.StatusPreconditionRequired {
+		t.Errorf("after wrong passwords = %d, want a challenge", code)
+	}
+	if code := request("ok", "ada@example.com", "pass"); code != http.StatusOK {
+		t.Errorf("with the challenge solved = %d, want 200", code)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/ostheperson/go-auth-service/internal/domain"
 	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
 	"github.com/ostheperson/go-auth-service/internal/util"
 )
 
@@ -18,44 +20,76 @@ const (
 	authorizationPayloadKey = "payload"
 )
 
-func AuthMiddleware(secret string, roles ...domain.Role) gin.HandlerFunc {
+func AuthMiddleware(
+	secret string,
+	network services.NetworkPolicy,
+	suspensions services.SuspensionService,
+	sessionlessUntil time.Time,
+	roles ...domain.Role,
+) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		JwtAuthMiddleware(secret)(c)
+		JwtAuthMiddleware(secret, network, suspensions, sessionlessUntil)(c)
 		RoleMiddleware(roles...)(c)
 	}
 }
 
-func JwtAuthMiddleware(secret string) gin.HandlerFunc {
+// NetworkMiddleware refuses clients outside the global network rules.
+// Role specific rules are applied by JwtAuthMiddleware once the role is
+// known.
+func NetworkMiddleware(network services.NetworkPolicy) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader(authorizationHeaderKey)
-
-		if len(authHeader) == 0 {
-			c.AbortWithStatusJSON(
-				http.StatusUnauthorized,
-				gin.H{"error": "authorization header is not provided"},
-			)
+		if !network.Allowed(c.ClientIP(), "") {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": helper.ErrNetworkNotAllowed})
 			return
 		}
+		c.Next()
+	}
+}
 
-		fields := strings.Split(authHeader, " ")
-		if len(fields) < 2 {
-			c.AbortWithStatusJSON(
-				http.StatusUnauthorized,
-				gin.H{"error": "invalid authorization header format"},
-			)
+// JwtAuthMiddleware admits valid access tokens of users who still exist and
+// aren't suspended, whose session has not been revoked and whose role may be
+// used from the client's network. Tokens issued before sessions were
+// tracked carry no session ID, they are only admitted until
+// sessionlessUntil.
+func JwtAuthMiddleware(
+	secret string,
+	network services.NetworkPolicy,
+	suspensions services.SuspensionService,
+	sessionlessUntil time.Time,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		payload, ok := authenticate(c, secret)
+		if !ok {
 			return
 		}
-		authorizationType := strings.ToLower(fields[0])
-		if authorizationType != authorizationTypeBearer {
-			err := fmt.Sprintf("unsupported authorization type %s", authorizationType)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err})
+		// exchanged tokens belong to the downstream service they were issued for
+		if len(payload.Audience) > 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": helper.ErrForeignAudience})
 			return
 		}
-
-		accessToken := fields[1]
-		payload, err := util.VerifyAndExtract(accessToken, secret)
+		if payload.Scope == util.ScopePasswordChange {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": helper.ErrPasswordChangeRequired})
+			return
+		}
+		if payload.SessionID == 0 && !time.Now().Before(sessionlessUntil) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": helper.ErrSessionRevoked})
+			return
+		}
+		access, err := suspensions.Access(payload.ID, payload.SessionID)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": helper.ErrInternalError})
+			return
+		}
+		if !access.Found || (payload.SessionID != 0 && !access.Session) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": helper.ErrSessionRevoked})
+			return
+		}
+		if !network.Allowed(c.ClientIP(), payload.Role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": helper.ErrNetworkNotAllowed})
+			return
+		}
+		if access.Suspended {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": helper.ErrAccountSuspended})
 			return
 		}
 		c.Set(authorizationPayloadKey, payload)
@@ -63,6 +97,60 @@ func JwtAuthMiddleware(secret string) gin.HandlerFunc {
 	}
 }
 
+// PasswordChangeMiddleware only admits the restricted tokens handed out at
+// sign-in when a password change is required.
+func PasswordChangeMiddleware(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		payload, ok := authenticate(c, secret)
+		if !ok {
+			return
+		}
+		if payload.Scope != util.ScopePasswordChange || len(payload.Audience) > 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": helper.ErrPasswordChangeToken})
+			return
+		}
+		c.Set(authorizationPayloadKey, payload)
+		c.Next()
+	}
+}
+
+// authenticate verifies the bearer token, aborting the request if it is
+// missing or invalid.
+func authenticate(c *gin.Context, secret string) (*util.JwtCustomClaims, bool) {
+	authHeader := c.GetHeader(authorizationHeaderKey)
+
+	if len(authHeader) == 0 {
+		c.AbortWithStatusJSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "authorization header is not provided"},
+		)
+		return nil, false
+	}
+
+	fields := strings.Split(authHeader, " ")
+	if len(fields) < 2 {
+		c.AbortWithStatusJSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "invalid authorization header format"},
+		)
+		return nil, false
+	}
+	authorizationType := strings.ToLower(fields[0])
+	if authorizationType != authorizationTypeBearer {
+		err := fmt.Sprintf("unsupported authorization type %s", authorizationType)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err})
+		return nil, false
+	}
+
+	accessToken := fields[1]
+	payload, err := util.VerifyAndExtract(accessToken, secret)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return payload, true
+}
+
 func RoleMiddleware(roles ...domain.Role) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		claims, exists := c.Get(authorizationPayloadKey)
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/services"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+const testSecret = "secret"
+
+func TestJwtAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := dbtest.New(t)
+	network, err := services.NewNetworkPolicy(&domain.Env{}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := services.NewTokenService(db, 5)
+	suspensions := services.NewSuspensionService(db)
+	user := &domain.User{Username: "ada", Email: "ada@example.com", Password: "x", Role: domain.UserRole}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	session, err := tokens.CreateToken(domain.REFRESH, "refresh", user.ID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSession, err := util.CreateSessionAccessToken(user, session.ID, testSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionless, err := util.CreateAccessToken(user, testSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(cutoff time.Time, token string) int {
+		r := gin.New()
+		r.GET("/", JwtAuthMiddleware(
+			testSecret,
+			network,
+			suspensions,
+			cutoff,
+		), func(c *gin.Context) { c.Status(http.StatusOK) })
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(authorizationHeaderKey, "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	if code := request(past, withSession); code != http.StatusOK {
+		t.Errorf("token with a session = %d, want 200", code)
+	}
+	if code := request(future, sessionless); code != http.StatusOK {
+		t.Errorf("sessionless token before the cutoff = %d, want 200", code)
+	}
+	if code := request(past, sessionless); code != http.StatusUnauthorized {
+		t.Errorf("sessionless token after the cutoff = %d, want 401", code)
+	}
+	if code := request(time.Time{}, sessionless); code != http.StatusUnauthorized {
+		t.Errorf("sessionless token without a cutoff = %d, want 401", code)
+	}
+
+	lapsed := time.Now().Add(-time.Minute)
+	if err := suspensions.Suspend(user, "spam", &lapsed, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := request(past, withSession); code != http.StatusOK {
+		t.Errorf("token of a user whose suspension ran out = %d, want 200", code)
+	}
+	if err := suspensions.Suspend(user, "spam", nil, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := request(past, withSession); code != http.StatusForbidden {
+		t.Errorf("token of a suspended user = %d, want 403", code)
+	}
+	if err := suspensions.Unsuspend(user); err != nil {
+		t.Fatal(err)
+	}
+
+	other := &domain.User{Username: "bob", Email: "bob@example.com", Password: "x", Role: domain.UserRole}
+	if err := db.Create(other).Error; err != nil {
+		t.Fatal(err)
+	}
+	borrowed, err := util.CreateSessionAccessToken(other, session.ID, testSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := request(past, borrowed); code != http.StatusUnauthorized {
+		t.Errorf("token naming another user's session = %d, want 401", code)
+	}
+
+	if err := tokens.DeleteTokenByID(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := request(future, withSession); code != http.StatusUnauthorized {
+		t.Errorf("token of a revoked session = %d, want 401", code)
+	}
+	db.Delete(user)
+	if code := request(future, sessionless); code != http.StatusUnauthorized {
+		t.Errorf("sessionless token of a deleted user = %d, want 401", code)
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/services"
+)
+
+// RateLimitRule limits requests that share a key. Key returns "" when the
+// rule does not apply to a request.
+type RateLimitRule struct {
+	Name  string
+	Limit services.RateLimit
+	Key   func(c *gin.Context) string
+}
+
+func ByIP(limit services.RateLimit) RateLimitRule {
+	return RateLimitRule{Name: "ip", Limit: limit, Key: func(c *gin.Context) string {
+		return c.ClientIP()
+	}}
+}
+
+// ByAccount keys on the email or username in the request body, so one
+// account can not be targeted from many addresses.
+func ByAccount(limit services.RateLimit) RateLimitRule {
+	return RateLimitRule{Name: "account", Limit: limit, Key: accountIdentifier}
+}
+
+// ByRoute shares one bucket between every caller of a route.
+func ByRoute(limit services.RateLimit) RateLimitRule {
+	return RateLimitRule{Name: "route", Limit: limit, Key: func(c *gin.Context) string {
+		return "*"
+	}}
+}
+
+// RateLimitMiddleware takes a token from every matching rule's bucket and
+// rejects the request with 429 once any of them is empty. The most
+// restrictive bucket is reported in the RateLimit-* headers. Store errors
+// are logged and the request is let through.
+func RateLimitMiddleware(
+	store services.RateLimitStore,
+	l *log.Logger,
+	rules ...RateLimitRule,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var tightest *services.RateLimitResult
+		var tightestLimit services.RateLimit
+		for _, rule := range rules {
+			if rule.Limit.Burst <= 0 {
+				continue
+			}
+			key := rule.Key(c)
+			if key == "" {
+				continue
+			}
+			result, err := store.Take(rule.Name+":"+c.FullPath()+":"+key, rule.Limit)
+			if err != nil {
+				l.Printf("rate limit %s: %v", rule.Name, err)
+				continue
+			}
+			if tightest == nil || !result.Allowed ||
+				(tightest.Allowed && result.Remaining < tightest.Remaining) {
+				tightest = &result
+				tightestLimit = rule.Limit
+			}
+			if !result.Allowed {
+				break
+			}
+		}
+		if tightest == nil {
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(tightestLimit.Burst))
+		c.Header("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+		c.Header("RateLimit-Reset", seconds(tightest.Reset))
+		if !tightest.Allowed {
+			c.Header("Retry-After", seconds(tightest.RetryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": helper.ErrTooManyRequests})
+			return
+		}
+		c.Next()
+	}
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// accountIdentifier reads the email or username from a JSON or form body,
+// falling back to the email query parameter, and puts the body back for the
+// handler.
+func accountIdentifier(c *gin.Context) string {
+	var details struct {
+		Email    string `json:"email"`
+		Username string `json:"username"`
+	}
+	if c.ContentType() == binding.MIMEJSON {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return ""
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		if json.Unmarshal(body, &details) != nil {
+			return ""
+		}
+	} else {
+		details.Email = c.PostForm("email")
+		details.Username = c.PostForm("username")
+	}
+	identifier := details.Email
+	if identifier == "" {
+		identifier = details.Username
+	}
+	if identifier == "" {
+		identifier = c.Query("email")
+	}
+	return strings.ToLower(strings.TrimSpace(identifier))
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/services"
+)
+
+func TestByIPIgnoresForgedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newEngine := func(env *domain.Env) *gin.Engine {
+		r := gin.New()
+		if err := trustProxies(r, env); err != nil {
+			t.Fatal(err)
+		}
+		limit := services.RateLimit{Burst: 2, Window: time.Minute}
+		r.POST("/auth/user/signin", RateLimitMiddleware(
+			services.NewMemoryRateLimitStore(),
+			log.New(io.Discard, "", 0),
+			ByIP(limit),
+		), func(c *gin.Context) { c.Status(http.StatusOK) })
+		return r
+	}
+	request := func(r *gin.Engine, remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodPost, "/auth/user/signin", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// a client talking to us directly can't pick its own bucket
+	r := newEngine(&domain.Env{})
+	codes := []int{
+		request(r, "203.0.113.7:5000", "198.51.100.1"),
+		request(r, "203.0.113.7:5000", "198.51.100.2"),
+		request(r, "203.0.113.7:5000", "198.51.100.3"),
+	}
+	if codes[2] != http.StatusTooManyRequests {
+		t.Errorf("codes with a new X-Forwarded-For each time = %v, want the third limited", codes)
+	}
+
+	// behind a trusted proxy the forwarded address is the client
+	r = newEngine(&domain.Env{TrustedProxies: []string{"10.0.0.0/8"}})
+	for i := 0; i < 3; i++ {
+		request(r, "10.0.0.1:5000", "198.51.100.1")
+	}
+	if code := request(r, "10.0.0.1:5000", "198.51.100.2"); code != http.StatusOK {
+		t.Errorf("another client behind the proxy = %d, want 200", code)
+	}
+	if code := request(r, "203.0.113.7:5000", "10.0.0.1"); code != http.StatusOK {
+		t.Errorf("untrusted client forging the proxy address = %d, want its own bucket", code)
+	}
+	if code := request(r, "10.0.0.1:5000", "198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Errorf("limited client behind the proxy = %d, want 429", code)
+	}
+}
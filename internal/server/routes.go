@@ -2,6 +2,7 @@ package server
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -11,36 +12,153 @@ import (
 	"github.com/ostheperson/go-auth-service/internal/services"
 )
 
+// trustProxies makes c.ClientIP() read forwarding headers only from the
+// configured proxies, without this gin believes X-Forwarded-For from anyone.
+func trustProxies(r *gin.Engine, env *domain.Env) error {
+	if err := r.SetTrustedProxies(env.TrustedProxies); err != nil {
+		return err
+	}
+	r.TrustedPlatform = env.TrustedPlatform
+	return nil
+}
+
 func RegisterRoutes(s *domain.Server) http.Handler {
 	r := gin.Default()
+	if err := trustProxies(r, s.Env); err != nil {
+		s.L.Fatal(err)
+	}
 
 	hh := NewHelloHandler(s)
 	r.GET("/", hh.HelloWorldHandler)
 	r.GET("/health", hh.healthHandler)
 	const (
-		authRoute = "/auth"
-		userRoute = "/users"
+		authRoute  = "/auth"
+		userRoute  = "/users"
+		adminRoute = "/admin"
 	)
+	network, err := services.NewNetworkPolicy(s.Env, s.Db.GetClient())
+	if err != nil {
+		s.L.Fatal(err)
+	}
+	r.Use(NetworkMiddleware(network))
+	auditService := services.NewAuditService(s.Db.GetClient())
+	suspensions := services.NewSuspensionService(s.Db.GetClient())
 	mailer := integrations.NewMailerClient(s.Env.POSTMARK_API_KEY)
-	tokenService := services.NewTokenService(s.Db.GetClient())
-	ah := handlers.NewAuthHandler(s, mailer, tokenService)
-	uh := handlers.NewUsersHandler(s)
+	tokenService := services.NewTokenService(s.Db.GetClient(), s.Env.CodeMaxAttempts)
+	hasherConfig, err := services.NewPasswordHasherConfig(s.Env)
+	if err != nil {
+		s.L.Fatal(err)
+	}
+	hasher := services.NewPasswordHasher(hasherConfig)
+	admins := services.NewAdminGuard()
+	policy, err := services.NewPasswordPolicy(services.NewPasswordPolicyConfig(s.Env))
+	if err != nil {
+		s.L.Fatal(err)
+	}
+	ah := handlers.NewAuthHandler(
+		s,
+		mailer,
+		tokenService,
+		services.NewCredentialVerifier(s.Env.AuthBackend, s.Env, s.Db.GetClient(), hasher),
+		services.NewCredentialVerifier(s.Env.AdminAuthBackend, s.Env, s.Db.GetClient(), hasher),
+		policy,
+		hasher,
+		services.NewPasswordHistoryService(s.Db.GetClient(), hasher, s.Env.PasswordHistorySize),
+		services.NewLockoutService(services.NewLockoutConfig(s.Env), s.Db.GetClient()),
+		services.NewLoginHistoryService(s.Db.GetClient()),
+		network,
+		admins,
+	)
+	nh := handlers.NewNetworkHandler(s, network, auditService)
+	adh := handlers.NewAuditHandler(s, auditService)
+	ih := handlers.NewInviteHandler(ah, auditService)
+	uh := handlers.NewUsersHandler(
+		s,
+		suspensions,
+		services.NewUserImporter(s.Db.GetClient(), hasher),
+		services.NewUserExporter(s.Db.GetClient()),
+		services.NewUserRetentionService(s.Db.GetClient()),
+		auditService,
+		admins,
+	)
+	samlService, err := services.NewSAMLService(s.Env, s.Db.GetClient(), hasher)
+	if err != nil {
+		s.L.Fatal(err)
+	}
+	sh := handlers.NewSAMLHandler(ah, samlService, auditService)
+	rateLimitStore, err := services.NewRateLimitStore(s.Env.RateLimitStore, s.Db.GetClient())
+	if err != nil {
+		s.L.Fatal(err)
+	}
+	rateLimit := RateLimitMiddleware(
+		rateLimitStore,
+		s.L,
+		ByIP(services.RateLimit{
+			Burst:  int(s.Env.RateLimitIPBurst),
+			Window: time.Duration(s.Env.RateLimitIPWindowMinutes) * time.Minute,
+		}),
+		ByAccount(services.RateLimit{
+			Burst:  int(s.Env.RateLimitAccountBurst),
+			Window: time.Duration(s.Env.RateLimitAccountWindowMinutes) * time.Minute,
+		}),
+		ByRoute(services.RateLimit{
+			Burst:  int(s.Env.RateLimitRouteBurst),
+			Window: time.Duration(s.Env.RateLimitRouteWindowMinutes) * time.Minute,
+		}),
+	)
+	challengeVerifier, err := services.NewChallengeVerifier(s.Env, rateLimitStore)
+	if err != nil {
+		s.L.Fatal(err)
+	}
+	ch := handlers.NewChallengeHandler(s, challengeVerifier)
+	challenge := ChallengeMiddleware(
+		challengeVerifier,
+		rateLimitStore,
+		s.L,
+		services.RateLimit{
+			Burst:  int(s.Env.ChallengeIPBurst),
+			Window: time.Duration(s.Env.ChallengeIPWindowMinutes) * time.Minute,
+		},
+		services.RateLimit{
+			Burst:  int(s.Env.ChallengeFailureBurst),
+			Window: time.Duration(s.Env.ChallengeFailureWindowMinutes) * time.Minute,
+		},
+	)
 
 	authRoutes := r.Group(authRoute)
 	{
-		authRoutes.POST("/user/signup", ah.SignUp)
-		authRoutes.POST("/user/signin", ah.SignIn)
-		authRoutes.POST("/admin/signin", ah.SignIn)
+		authRoutes.GET("/challenge", ch.GetChallenge)
+		authRoutes.POST("/user/signup", challenge, ah.SignUp)
+		authRoutes.POST("/user/signin", rateLimit, challenge, ah.SignIn)
+		authRoutes.POST("/admin/signin", rateLimit, challenge, ah.SignInAdmin)
+		authRoutes.POST("/unlock/request", rateLimit, ah.RequestUnlock)
+		authRoutes.POST("/unlock/confirm", rateLimit, ah.ConfirmUnlock)
 		authRoutes.POST("/refresh", ah.RefreshToken)
-		authRoutes.POST("/email-verify/request", ah.ResendVerifyEmail)
+		authRoutes.POST("/token", ah.ExchangeToken)
+		authRoutes.POST("/email-verify/request", rateLimit, ah.ResendVerifyEmail)
 		authRoutes.POST("/email-verify/confirm", ah.ConfirmEmail)
-		authRoutes.POST("/reset-password/request", ah.ForgotPassword)
+		authRoutes.POST("/reset-password/request", rateLimit, challenge, ah.ForgotPassword)
 		authRoutes.POST("/reset-password/confirm", ah.ResetPassword)
+		authRoutes.POST("/invite/accept", rateLimit, ih.AcceptInvite)
+		authRoutes.POST("/deletion/cancel", rateLimit, ah.CancelDeletion)
+		authRoutes.POST(
+			"/password/change",
+			PasswordChangeMiddleware(s.Env.AccessTokenSecret),
+			ah.ChangeExpiredPassword,
+		)
+		authRoutes.GET("/saml/:slug/metadata", sh.Metadata)
+		authRoutes.GET("/saml/:slug/login", sh.Login)
+		authRoutes.POST("/saml/:slug/acs", sh.ACS)
 	}
 
 	// USERS
 	userRoutes := r.Group(userRoute)
-	userRoutes.Use(JwtAuthMiddleware(s.Env.AccessTokenSecret))
+	userRoutes.Use(JwtAuthMiddleware(
+		s.Env.AccessTokenSecret,
+		network,
+		suspensions,
+		s.Env.SessionlessTokensUntil,
+	))
 	{
 		userRoutes.GET("", RoleMiddleware(domain.AdminRole), uh.GetUsers)
 		userRoutes.GET(
@@ -59,6 +177,61 @@ func RegisterRoutes(s *domain.Server) http.Handler {
 			uh.RemoveUser,
 		)
 		userRoutes.DELETE("/all", RoleMiddleware(domain.AdminRole))
+		userRoutes.GET(
+			"/me/logins",
+			RoleMiddleware(domain.AdminRole, domain.UserRole),
+			ah.GetLogins,
+		)
+		userRoutes.DELETE(
+			"/me",
+			RoleMiddleware(domain.AdminRole, domain.UserRole),
+			rateLimit,
+			ah.DeleteAccount,
+		)
+		userRoutes.POST(
+			"/me/password",
+			RoleMiddleware(domain.AdminRole, domain.UserRole),
+			rateLimit,
+			ah.ChangePassword,
+		)
+	}
+
+	// ADMIN
+	adminRoutes := r.Group(adminRoute)
+	adminRoutes.Use(
+		JwtAuthMiddleware(
+			s.Env.AccessTokenSecret,
+			network,
+			suspensions,
+			s.Env.SessionlessTokensUntil,
+		),
+		RoleMiddleware(domain.AdminRole),
+	)
+	{
+		adminRoutes.POST("/users", ih.CreateUser)
+		adminRoutes.POST("/users/import", uh.ImportUsers)
+		adminRoutes.GET("/users/export", uh.ExportUsers)
+		adminRoutes.POST("/users/:id/force-password-change", uh.ForcePasswordChange)
+		adminRoutes.PUT("/users/:id/role", uh.ChangeRole)
+		adminRoutes.POST("/users/:id/restore", uh.RestoreUser)
+		adminRoutes.POST("/users/:id/release-identifiers", uh.ReleaseIdentifiers)
+		adminRoutes.POST("/users/:id/suspension", uh.SuspendUser)
+		adminRoutes.DELETE("/users/:id/suspension", uh.UnsuspendUser)
+		adminRoutes.GET("/invites", ih.GetInvites)
+		adminRoutes.POST("/invites/:id/resend", ih.ResendInvite)
+		adminRoutes.DELETE("/invites/:id", ih.RevokeInvite)
+		adminRoutes.GET("/lockouts", ah.GetLockouts)
+		adminRoutes.DELETE("/users/:id/lockout", ah.ClearLockout)
+		adminRoutes.PUT("/users/:id/saml-connection", sh.LinkUser)
+		adminRoutes.GET("/saml/connections", sh.GetConnections)
+		adminRoutes.POST("/saml/connections", sh.CreateConnection)
+		adminRoutes.PUT("/saml/connections/:id", sh.UpdateConnection)
+		adminRoutes.DELETE("/saml/connections/:id", sh.RemoveConnection)
+		adminRoutes.GET("/network-rules", nh.GetRules)
+		adminRoutes.POST("/network-rules", nh.CreateRule)
+		adminRoutes.PUT("/network-rules/:id", nh.UpdateRule)
+		adminRoutes.DELETE("/network-rules/:id", nh.RemoveRule)
+		adminRoutes.GET("/audit-logs", adh.GetAuditLogs)
 	}
 
 	return r
@@ -9,6 +9,7 @@ import (
 
 	"github.com/ostheperson/go-auth-service/internal/database"
 	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/jobs"
 )
 
 func NewServer() *http.Server {
@@ -35,8 +36,8 @@ func NewServer() *http.Server {
 		ErrorLog:     l,
 	}
 
-	// jobservice := jobs.NewJobService(env.Timezone, db.GetClient())
-	// jobservice.Start()
+	jobservice := jobs.NewJobService(env, db.GetClient())
+	jobservice.Start()
 
 	return server
 }
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+var ErrLastAdmin = errors.New("the last admin can't lose the role")
+
+// AdminGuard keeps at least one admin able to administer the service:
+// one that isn't deleted, scheduled for deletion or suspended.
+type AdminGuard interface {
+	// Check returns ErrLastAdmin when the users with ids include an admin
+	// and no other admin would be left once they lose the role. It locks
+	// the admins until tx ends, so call it in the transaction that takes
+	// the role away, or two of them could each count on the other's admin.
+	Check(tx *gorm.DB, ids ...uint) error
+}
+
+type adminGuard struct{}
+
+func NewAdminGuard() AdminGuard {
+	return &adminGuard{}
+}
+
+func (g *adminGuard) Check(tx *gorm.DB, ids ...uint) error {
+	var admins []domain.User
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").
+		Where("role = ? AND deletion_scheduled_at IS NULL", domain.AdminRole).
+		Scopes(notSuspended(time.Now())).
+		Find(&admins).Error
+	if err != nil {
+		return err
+	}
+	losing := map[uint]bool{}
+	for _, id := range ids {
+		losing[id] = true
+	}
+	affected, left := false, 0
+	for _, admin := range admins {
+		if losing[admin.ID] {
+			affected = true
+		} else {
+			left++
+		}
+	}
+	if affected && left == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func TestAdminGuard(t *testing.T) {
+	db := dbtest.New(t)
+	guard := NewAdminGuard()
+	create := func(name string, role domain.Role) *domain.User {
+		user := &domain.User{Username: name, Email: name + "@example.com", Password: "x", Role: role}
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	ada := create("ada", domain.AdminRole)
+	bob := create("bob", domain.AdminRole)
+	cy := create("cy", domain.UserRole)
+	leaving := create("dee", domain.AdminRole)
+	db.Model(leaving).Update("deletion_scheduled_at", time.Now().Add(time.Hour))
+	gone := create("eve", domain.AdminRole)
+	db.Delete(gone)
+	suspended := create("fay", domain.AdminRole)
+	db.Model(suspended).Update("suspended_at", time.Now())
+
+	tests := []struct {
+		name string
+		ids  []uint
+		err  error
+	}{
+		{"nobody", nil, nil},
+		{"a user", []uint{cy.ID}, nil},
+		{"one of two admins", []uint{ada.ID}, nil},
+		{"both admins", []uint{ada.ID, bob.ID}, ErrLastAdmin},
+		{"an admin and a user", []uint{bob.ID, cy.ID}, nil},
+		{"an admin leaving anyway", []uint{leaving.ID}, nil},
+		{"a deleted admin", []uint{gone.ID}, nil},
+		{"a suspended admin", []uint{suspended.ID}, nil},
+		{"unknown", []uint{999}, nil},
+	}
+	for _, tt := range tests {
+		if err := guard.Check(db, tt.ids...); !errors.Is(err, tt.err) {
+			t.Errorf("%s: Check = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	db.Model(bob).Update("role", domain.UserRole)
+	if err := guard.Check(db, ada.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("the last admin: Check = %v, want ErrLastAdmin", err)
+	}
+	if err := guard.Check(db, cy.ID); err != nil {
+		t.Errorf("a user next to the last admin: Check = %v", err)
+	}
+	db.Model(suspended).Update("suspended_until", time.Now().Add(-time.Minute))
+	if err := guard.Check(db, ada.ID); err != nil {
+		t.Errorf("an admin next to one whose suspension ran out: Check = %v", err)
+	}
+}
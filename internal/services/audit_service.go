@@ -0,0 +1,68 @@
+package services
+
+import (
+	"encoding/json"
+
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+// auditKeyset lists audit log entries newest first.
+var auditKeyset = util.Keyset{Sort: "-created_at", Column: "created_at", Desc: true, Time: true}
+
+type AuditService interface {
+	// Record stores entry with details encoded as JSON.
+	Record(entry domain.AuditLog, details interface{}) error
+	// List returns a page of at most limit entries, newest first, starting
+	// after cursor or from the newest entry when cursor is nil.
+	List(cursor *util.Cursor, limit int) ([]domain.AuditLog, *domain.PageMeta, error)
+}
+
+type auditService struct {
+	db *gorm.DB
+}
+
+func NewAuditService(db *gorm.DB) AuditService {
+	return &auditService{db: db}
+}
+
+func (s *auditService) Record(entry domain.AuditLog, details interface{}) error {
+	if details != nil {
+		buf, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		entry.Details = string(buf)
+	}
+	return s.db.Create(&entry).Error
+}
+
+func (s *auditService) List(cursor *util.Cursor, limit int) ([]domain.AuditLog, *domain.PageMeta, error) {
+	meta := &domain.PageMeta{Limit: limit}
+	if err := s.db.Model(&domain.AuditLog{}).Count(&meta.Total).Error; err != nil {
+		return nil, nil, err
+	}
+	var entries []domain.AuditLog
+	query := s.db.Order(auditKeyset.Order())
+	if cursor != nil {
+		var err error
+		if query, err = auditKeyset.Seek(s.db, cursor); err != nil {
+			return nil, nil, err
+		}
+	}
+	// one extra row tells whether there is another page
+	if err := query.Limit(limit + 1).Find(&entries).Error; err != nil {
+		return nil, nil, err
+	}
+	entries, meta.NextCursor, meta.PrevCursor = util.KeysetPage(
+		entries,
+		limit,
+		cursor,
+		func(entry *domain.AuditLog, before bool) *util.Cursor {
+			return auditKeyset.TimeCursor(entry.CreatedAt, entry.ID, before)
+		},
+	)
+	return entries, meta, nil
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ostheperson/go-auth-service/integrations"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+const (
+	NoChallenge        = "none"
+	PowChallenge       = "pow"
+	HCaptchaChallenge  = "hcaptcha"
+	RecaptchaChallenge = "recaptcha"
+	TurnstileChallenge = "turnstile"
+	StubChallenge      = "stub"
+)
+
+var ErrChallengeFailed = errors.New("challenge failed")
+
+// Challenge tells the client how to prove it is not a bot. For proof of
+// work it solves Token, for hosted CAPTCHAs it renders the widget for
+// SiteKey.
+type Challenge struct {
+	Type       string `json:"type"`
+	SiteKey    string `json:"site_key,omitempty"`
+	Token      string `json:"token,omitempty"`
+	Difficulty int    `json:"difficulty,omitempty"`
+}
+
+type ChallengeVerifier interface {
+	Issue() (*Challenge, error)
+	// Verify returns ErrChallengeFailed when response does not solve a
+	// challenge, other errors mean the check itself failed.
+	Verify(response, remoteIP string) error
+}
+
+// NewChallengeVerifier returns nil when challenges are disabled. Solved
+// proof of work stamps are remembered in store until they expire.
+func NewChallengeVerifier(env *domain.Env, store RateLimitStore) (ChallengeVerifier, error) {
+	switch env.ChallengeProvider {
+	case NoChallenge, "":
+		return nil, nil
+	case PowChallenge:
+		secret := []byte(env.PowSecret)
+		if len(secret) == 0 {
+			// stamps won't verify on other instances or after a restart
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, err
+			}
+		}
+		return NewPowChallengeVerifier(secret, env.PowDifficulty, 5*time.Minute, store), nil
+	case HCaptchaChallenge:
+		return NewHostedChallengeVerifier(
+			HCaptchaChallenge,
+			env.ChallengeSiteKey,
+			integrations.NewCaptchaClient(integrations.HCaptchaVerifyURL, env.ChallengeSecret),
+		), nil
+	case RecaptchaChallenge:
+		return NewHostedChallengeVerifier(
+			RecaptchaChallenge,
+			env.ChallengeSiteKey,
+			integrations.NewCaptchaClient(integrations.RecaptchaVerifyURL, env.ChallengeSecret),
+		), nil
+	case TurnstileChallenge:
+		return NewHostedChallengeVerifier(
+			TurnstileChallenge,
+			env.ChallengeSiteKey,
+			integrations.NewCaptchaClient(integrations.TurnstileVerifyURL, env.ChallengeSecret),
+		), nil
+	case StubChallenge:
+		// anyone who knows the answer passes, never let it guard a real
+		// deployment
+		if env.APP_ENV != "local" && env.APP_ENV != "test" {
+			return nil, fmt.Errorf("challenge provider %s needs APP_ENV local or test", StubChallenge)
+		}
+		return NewStubChallengeVerifier(env.ChallengeSecret), nil
+	default:
+		return nil, fmt.Errorf("unknown challenge provider %s", env.ChallengeProvider)
+	}
+}
+
+type hostedChallengeVerifier struct {
+	provider string
+	siteKey  string
+	captcha  integrations.CaptchaService
+}
+
+func NewHostedChallengeVerifier(
+	provider, siteKey string,
+	captcha integrations.CaptchaService,
+) ChallengeVerifier {
+	return &hostedChallengeVerifier{provider: provider, siteKey: siteKey, captcha: captcha}
+}
+
+func (v *hostedChallengeVerifier) Issue() (*Challenge, error) {
+	return &Challenge{Type: v.provider, SiteKey: v.siteKey}, nil
+}
+
+func (v *hostedChallengeVerifier) Verify(response, remoteIP string) error {
+	if response == "" {
+		return ErrChallengeFailed
+	}
+	err := v.captcha.Verify(response, remoteIP)
+	if errors.Is(err, integrations.ErrCaptchaRejected) {
+		return ErrChallengeFailed
+	}
+	return err
+}
+
+type stubChallengeVerifier struct {
+	answer string
+}
+
+// NewStubChallengeVerifier accepts exactly answer. It is meant for tests
+// and local development.
+func NewStubChallengeVerifier(answer string) ChallengeVerifier {
+	return &stubChallengeVerifier{answer: answer}
+}
+
+func (v *stubChallengeVerifier) Issue() (*Challenge, error) {
+	return &Challenge{Type: StubChallenge}, nil
+}
+
+func (v *stubChallengeVerifier) Verify(response, remoteIP string) error {
+	if v.answer == "" || !hmac.Equal([]byte(response), []byte(v.answer)) {
+		return ErrChallengeFailed
+	}
+	return nil
+}
+
+// powChallengeVerifier is a hashcash-style proof of work. A challenge is a
+// signed stamp "expiry.random.mac"; the client answers with "stamp:nonce"
+// where SHA-256("stamp:nonce") starts with difficulty zero bits. Solved
+// stamps can't be replayed: each takes the single token of a bucket in used
+// that refills only once the stamp has expired, and the store forgets it
+// after that.
+type powChallengeVerifier struct {
+	secret     []byte
+	difficulty int
+	ttl        time.Duration
+	now        func() time.Time
+	used       RateLimitStore
+}
+
+func NewPowChallengeVerifier(
+	secret []byte,
+	difficulty int,
+	ttl time.Duration,
+	used RateLimitStore,
+) ChallengeVerifier {
+	return &powChallengeVerifier{
+		secret:     secret,
+		difficulty: difficulty,
+		ttl:        ttl,
+		now:        time.Now,
+		used:       used,
+	}
+}
+
+func (v *powChallengeVerifier) Issue() (*Challenge, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	expires := strconv.FormatInt(v.now().Add(v.ttl).Unix(), 10)
+	payload := expires + "." + strconv.Itoa(v.difficulty) + "." + base64.RawURLEncoding.EncodeToString(random)
+	return &Challenge{
+		Type:       PowChallenge,
+		Token:      payload + "." + v.sign(payload),
+		Difficulty: v.difficulty,
+	}, nil
+}
+
+func (v *powChallengeVerifier) Verify(response, remoteIP string) error {
+	stamp, nonce, ok := strings.Cut(response, ":")
+	if !ok || nonce == "" {
+		return ErrChallengeFailed
+	}
+	i := strings.LastIndex(stamp, ".")
+	if i < 0 || !hmac.Equal([]byte(stamp[i+1:]), []byte(v.sign(stamp[:i]))) {
+		return ErrChallengeFailed
+	}
+	parts := strings.Split(stamp[:i], ".")
+	if len(parts) != 3 {
+		return ErrChallengeFailed
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || v.now().Unix() > expiry {
+		return ErrChallengeFailed
+	}
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil || difficulty < v.difficulty {
+		return ErrChallengeFailed
+	}
+	sum := sha256.Sum256([]byte(response))
+	if LeadingZeroBits(sum[:]) < difficulty {
+		return ErrChallengeFailed
+	}
+
+	result, err := v.used.Take("challenge:stamp:"+stamp, RateLimit{Burst: 1, Window: v.ttl})
+	if err != nil {
+		return err
+	}
+	if !result.Allowed {
+		return ErrChallengeFailed
+	}
+	return nil
+}
+
+func (v *powChallengeVerifier) sign(payload string) string {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func LeadingZeroBits(b []byte) int {
+	n := 0
+	for len(b) >= 8 {
+		word := binary.BigEndian.Uint64(b)
+		if word != 0 {
+			return n + bits.LeadingZeros64(word)
+		}
+		n += 64
+		b = b[8:]
+	}
+	for _, c := range b {
+		if c != 0 {
+			return n + bits.LeadingZeros8(c)
+		}
+		n += 8
+	}
+	return n
+}
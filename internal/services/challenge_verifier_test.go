@@ -0,0 +1,104 @@
+package services
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func solve(token string, difficulty int) string {
+	for nonce := 0; ; nonce++ {
+		response := token + ":" + strconv.Itoa(nonce)
+		sum := sha256.Sum256([]byte(response))
+		if LeadingZeroBits(sum[:]) >= difficulty {
+			return response
+		}
+	}
+}
+
+// unsolved returns a response to token that falls short of difficulty.
+func unsolved(token string, difficulty int) string {
+	for nonce := 0; ; nonce++ {
+		response := token + ":" + strconv.Itoa(nonce)
+		sum := sha256.Sum256([]byte(response))
+		if LeadingZeroBits(sum[:]) < difficulty {
+			return response
+		}
+	}
+}
+
+func TestPowChallengeVerifier(t *testing.T) {
+	verifier := NewPowChallengeVerifier([]byte("secret"), 8, time.Minute, NewMemoryRateLimitStore()).(*powChallengeVerifier)
+	challenge, err := verifier.Issue()
+	if err != nil {
+		t.Fatalf("error issuing challenge: %v", err)
+	}
+
+	if err := verifier.Verify(unsolved(challenge.Token, challenge.Difficulty), ""); err != ErrChallengeFailed {
+		t.Errorf("unsolved challenge: expected ErrChallengeFailed, got %v", err)
+	}
+	response := solve(challenge.Token, challenge.Difficulty)
+	if err := verifier.Verify(response, ""); err != nil {
+		t.Fatalf("solved challenge rejected: %v", err)
+	}
+	if err := verifier.Verify(response, ""); err != ErrChallengeFailed {
+		t.Errorf("replayed challenge: expected ErrChallengeFailed, got %v", err)
+	}
+
+	other := NewPowChallengeVerifier([]byte("other"), 8, time.Minute, NewMemoryRateLimitStore())
+	forged, _ := other.Issue()
+	if err := verifier.Verify(solve(forged.Token, 8), ""); err != ErrChallengeFailed {
+		t.Errorf("forged challenge: expected ErrChallengeFailed, got %v", err)
+	}
+
+	challenge, _ = verifier.Issue()
+	verifier.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if err := verifier.Verify(solve(challenge.Token, 8), ""); err != ErrChallengeFailed {
+		t.Errorf("expired challenge: expected ErrChallengeFailed, got %v", err)
+	}
+}
+
+func TestPowChallengeVerifierSharedStore(t *testing.T) {
+	// instances sharing a store refuse stamps solved on another
+	store := NewMemoryRateLimitStore()
+	a := NewPowChallengeVerifier([]byte("secret"), 8, time.Minute, store)
+	b := NewPowChallengeVerifier([]byte("secret"), 8, time.Minute, store)
+	challenge, err := a.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := solve(challenge.Token, challenge.Difficulty)
+	if err := a.Verify(response, ""); err != nil {
+		t.Fatalf("solved challenge rejected: %v", err)
+	}
+	if err := b.Verify(response, ""); err != ErrChallengeFailed {
+		t.Errorf("challenge replayed on another instance: expected ErrChallengeFailed, got %v", err)
+	}
+}
+
+func TestNewChallengeVerifierStub(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	for appEnv, allowed := range map[string]bool{"local": true, "test": true, "production": false, "": false} {
+		env := &domain.Env{APP_ENV: appEnv, ChallengeProvider: StubChallenge, ChallengeSecret: "pass"}
+		verifier, err := NewChallengeVerifier(env, store)
+		if allowed && (err != nil || verifier == nil) {
+			t.Errorf("APP_ENV %q: stub refused: %v", appEnv, err)
+		}
+		if !allowed && err == nil {
+			t.Errorf("APP_ENV %q: stub allowed", appEnv)
+		}
+	}
+}
+
+func TestStubChallengeVerifier(t *testing.T) {
+	verifier := NewStubChallengeVerifier("pass")
+	if err := verifier.Verify("pass", ""); err != nil {
+		t.Errorf("expected stub to accept its answer, got %v", err)
+	}
+	if err := verifier.Verify("fail", ""); err != ErrChallengeFailed {
+		t.Errorf("expected ErrChallengeFailed, got %v", err)
+	}
+}
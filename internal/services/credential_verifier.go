@@ -0,0 +1,75 @@
+package services
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+)
+
+const (
+	LocalBackend = "local"
+	LDAPBackend  = "ldap"
+)
+
+var ErrInvalidCredentials = errors.New(helper.ErrInvalidCredentials)
+
+// CredentialVerifier checks a sign-in identifier (email or username) and
+// password, returning the matching user. Backends that own the identity
+// provision the user on first sign-in.
+type CredentialVerifier interface {
+	Verify(identifier, password string) (*domain.User, error)
+}
+
+func NewCredentialVerifier(
+	backend string,
+	env *domain.Env,
+	db *gorm.DB,
+	hasher PasswordHasher,
+) CredentialVerifier {
+	switch backend {
+	case LDAPBackend:
+		return NewLDAPVerifier(NewLDAPConfig(env), db, hasher)
+	default:
+		return NewLocalVerifier(db, hasher)
+	}
+}
+
+type localVerifier struct {
+	db     *gorm.DB
+	hasher PasswordHasher
+}
+
+func NewLocalVerifier(db *gorm.DB, hasher PasswordHasher) CredentialVerifier {
+	return &localVerifier{db: db, hasher: hasher}
+}
+
+func (v *localVerifier) Verify(identifier, password string) (*domain.User, error) {
+	user := &domain.User{}
+	err := v.db.Where("email = ? OR username = ?", identifier, identifier).First(user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			v.hasher.Dummy(password)
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	ok, rehash, err := v.hasher.Verify(password, user.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if rehash {
+		// the password is only ever in hand at sign-in, so upgrade it now
+		if hash, err := v.hasher.Hash(password); err == nil {
+			if v.db.Model(user).Update("password", hash).Error == nil {
+				user.Password = hash
+			}
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+type LDAPConfig struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	BindDN             string
+	BindPassword       string
+	BaseDN             string
+	// UserFilter is an LDAP filter where every %s is replaced with the
+	// escaped sign-in identifier, e.g. (sAMAccountName=%s) for AD.
+	UserFilter        string
+	UsernameAttribute string
+	EmailAttribute    string
+	GroupAttribute    string
+	// GroupRoles maps group DNs to roles. A user in several mapped groups
+	// gets the most privileged role.
+	GroupRoles  map[string]domain.Role
+	DefaultRole domain.Role
+}
+
+func NewLDAPConfig(env *domain.Env) LDAPConfig {
+	return LDAPConfig{
+		URL:                env.LDAPURL,
+		StartTLS:           env.LDAPStartTLS,
+		InsecureSkipVerify: env.LDAPInsecureSkipVerify,
+		BindDN:             env.LDAPBindDN,
+		BindPassword:       env.LDAPBindPassword,
+		BaseDN:             env.LDAPBaseDN,
+		UserFilter:         env.LDAPUserFilter,
+		UsernameAttribute:  env.LDAPUsernameAttribute,
+		EmailAttribute:     env.LDAPEmailAttribute,
+		GroupAttribute:     env.LDAPGroupAttribute,
+		GroupRoles:         ParseGroupRoles(env.LDAPGroupRoles),
+		DefaultRole:        domain.Role(env.LDAPDefaultRole),
+	}
+}
+
+// ParseGroupRoles parses "role=groupDN" pairs separated by semicolons, since
+// DNs themselves contain commas.
+func ParseGroupRoles(s string) map[string]domain.Role {
+	roles := make(map[string]domain.Role)
+	for _, pair := range strings.Split(s, ";") {
+		role, group, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || group == "" {
+			continue
+		}
+		roles[strings.ToLower(strings.TrimSpace(group))] = domain.Role(strings.TrimSpace(role))
+	}
+	return roles
+}
+
+type ldapVerifier struct {
+	cfg    LDAPConfig
+	db     *gorm.DB
+	hasher PasswordHasher
+	admins AdminGuard
+}
+
+func NewLDAPVerifier(cfg LDAPConfig, db *gorm.DB, hasher PasswordHasher) CredentialVerifier {
+	return &ldapVerifier{cfg: cfg, db: db, hasher: hasher, admins: NewAdminGuard()}
+}
+
+func (v *ldapVerifier) Verify(identifier, password string) (*domain.User, error) {
+	// an empty password would be an unauthenticated bind, which most
+	// directories report as success
+	if identifier == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := v.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(v.cfg.BindDN, v.cfg.BindPassword); err != nil {
+		return nil, fmt.Errorf("ldap service bind: %w", err)
+	}
+	filter := strings.ReplaceAll(v.cfg.UserFilter, "%s", ldap.EscapeFilter(identifier))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		v.cfg.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		10,
+		false,
+		filter,
+		[]string{
+			v.cfg.UsernameAttribute,
+			v.cfg.EmailAttribute,
+			v.cfg.GroupAttribute,
+			"givenName",
+			"sn",
+		},
+		nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap user bind: %w", err)
+	}
+
+	role, ok := v.mapRole(entry.GetAttributeValues(v.cfg.GroupAttribute))
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return v.provision(entry, role)
+}
+
+func (v *ldapVerifier) dial() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: v.cfg.InsecureSkipVerify}
+	conn, err := ldap.DialURL(v.cfg.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial: %w", err)
+	}
+	conn.SetTimeout(10 * time.Second)
+	if v.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (v *ldapVerifier) mapRole(groups []string) (domain.Role, bool) {
+	var best domain.Role
+	for _, group := range groups {
+		role, ok := v.cfg.GroupRoles[strings.ToLower(group)]
+		if ok && len(domain.Roles[role]) > len(domain.Roles[best]) {
+			best = role
+		}
+	}
+	if best == "" {
+		best = v.cfg.DefaultRole
+	}
+	_, known := domain.Roles[best]
+	return best, known
+}
+
+// provision creates the directory user on first sign-in and keeps the
+// local copy in step with the directory on later ones. Only accounts the
+// directory created are linked, an email or username held by any other
+// account fails the sign-in. The role follows the directory's groups when
+// they change, so a role set here in between is kept until then. Groups
+// that no longer make the last admin an admin fail the sign-in with
+// ErrLastAdmin.
+func (v *ldapVerifier) provision(entry *ldap.Entry, role domain.Role) (*domain.User, error) {
+	email := entry.GetAttributeValue(v.cfg.EmailAttribute)
+	username := entry.GetAttributeValue(v.cfg.UsernameAttribute)
+	if email == "" || username == "" {
+		return nil, fmt.Errorf("ldap entry %s is missing %s or %s",
+			entry.DN, v.cfg.EmailAttribute, v.cfg.UsernameAttribute)
+	}
+
+	var existing []domain.User
+	err := v.db.Unscoped().Where("email = ? OR username = ?", email, username).Find(&existing).Error
+	if err != nil {
+		return nil, err
+	}
+	var user *domain.User
+	for i := range existing {
+		other := &existing[i]
+		if other.Email == email && other.AuthProvider == LDAPBackend && !other.DeletedAt.Valid {
+			user = other
+		}
+	}
+	for _, other := range existing {
+		if user == nil || other.ID != user.ID {
+			return nil, ErrInvalidCredentials
+		}
+	}
+	if user == nil {
+		// the directory owns the password, store something nobody knows
+		secret, err := util.GenerateSecret(32)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := v.hasher.Hash(secret)
+		if err != nil {
+			return nil, err
+		}
+		user = &domain.User{
+			Email:           email,
+			Username:        username,
+			Password:        hash,
+			AuthProvider:    LDAPBackend,
+			IsEmailVerified: true,
+			VerifiedAt:      time.Now(),
+		}
+	}
+	user.Firstname = entry.GetAttributeValue("givenName")
+	user.Lastname = entry.GetAttributeValue("sn")
+	var demoted []uint
+	if user.ProviderRole != role {
+		if user.Role == domain.AdminRole && role != domain.AdminRole {
+			demoted = append(demoted, user.ID)
+		}
+		user.Role = role
+		user.ProviderRole = role
+	}
+	err = v.db.Transaction(func(tx *gorm.DB) error {
+		if err := v.admins.Check(tx, demoted...); err != nil {
+			return err
+		}
+		return tx.Save(user).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
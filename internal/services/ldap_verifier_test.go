@@ -0,0 +1,287 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+const (
+	ldapServiceDN = "cn=svc,dc=example,dc=com"
+	ldapAdminsDN  = "cn=admins,ou=groups,dc=example,dc=com"
+	ldapStaffDN   = "cn=staff,ou=groups,dc=example,dc=com"
+)
+
+type ldapTestEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// ldapTestServer answers the simple binds and searches ldapVerifier makes,
+// standing in for a directory. Searches match entries having any attribute
+// value compared in the filter.
+type ldapTestServer struct {
+	mu      sync.Mutex
+	entries []*ldapTestEntry
+}
+
+func (s *ldapTestServer) set(e *ldapTestEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, old := range s.entries {
+		if old.dn == e.dn {
+			s.entries[i] = e
+			return
+		}
+	}
+	s.entries = append(s.entries, e)
+}
+
+func (s *ldapTestServer) start(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return "ldap://" + l.Addr().String()
+}
+
+func (s *ldapTestServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		var replies []*ber.Packet
+		switch op.Tag {
+		case 0: // bind
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := 49 // invalid credentials
+			if s.bind(dn, password) {
+				code = 0
+			}
+			replies = append(replies, ldapResult(id, 1, code))
+		case 3: // search
+			for _, e := range s.search(equalityValues(op.Children[6])) {
+				replies = append(replies, ldapEntry(id, e))
+			}
+			replies = append(replies, ldapResult(id, 5, 0))
+		default:
+			return
+		}
+		for _, reply := range replies {
+			if _, err := conn.Write(reply.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *ldapTestServer) bind(dn, password string) bool {
+	if dn == ldapServiceDN {
+		return password == "service"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if e.dn == dn {
+			return password != "" && password == e.password
+		}
+	}
+	return false
+}
+
+func (s *ldapTestServer) search(values []string) []*ldapTestEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found []*ldapTestEntry
+	for _, e := range s.entries {
+	match:
+		for _, attr := range e.attrs {
+			for _, have := range attr {
+				for _, want := range values {
+					if strings.EqualFold(have, want) {
+						found = append(found, e)
+						break match
+					}
+				}
+			}
+		}
+	}
+	return found
+}
+
+// equalityValues collects the values compared by the filter's
+// equalityMatch items.
+func equalityValues(filter *ber.Packet) []string {
+	if filter.ClassType == ber.ClassContext && filter.Tag == 3 && len(filter.Children) == 2 {
+		return []string{filter.Children[1].Data.String()}
+	}
+	var values []string
+	for _, child := range filter.Children {
+		values = append(values, equalityValues(child)...)
+	}
+	return values
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	packet := ber.NewSequence("")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	packet.AppendChild(op)
+	return packet
+}
+
+func ldapResult(id int64, tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return ldapMessage(id, op)
+}
+
+func ldapEntry(id int64, e *ldapTestEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 4, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+	attrs := ber.NewSequence("")
+	for name, values := range e.attrs {
+		attr := ber.NewSequence("")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return ldapMessage(id, op)
+}
+
+func ldapPerson(uid, password string, groups ...string) *ldapTestEntry {
+	return &ldapTestEntry{
+		dn:       "uid=" + uid + ",ou=people,dc=example,dc=com",
+		password: password,
+		attrs: map[string][]string{
+			"uid":       {uid},
+			"mail":      {uid + "@example.com"},
+			"givenName": {strings.ToUpper(uid[:1]) + uid[1:]},
+			"sn":        {"Example"},
+			"memberOf":  groups,
+		},
+	}
+}
+
+func TestLDAPVerifier(t *testing.T) {
+	directory := &ldapTestServer{}
+	directory.set(ldapPerson("ada", "ada-pw", ldapAdminsDN))
+	directory.set(ldapPerson("bob", "bob-pw", ldapStaffDN))
+	directory.set(ldapPerson("eve", "eve-pw", ldapAdminsDN))
+	directory.set(ldapPerson("mallory", "mallory-pw", ldapStaffDN))
+	url := directory.start(t)
+
+	db := dbtest.New(t)
+	hasher := NewPasswordHasher(PasswordHasherConfig{Algorithm: BcryptAlgorithm, BcryptCost: bcrypt.MinCost})
+	verifier := NewLDAPVerifier(LDAPConfig{
+		URL:               url,
+		BindDN:            ldapServiceDN,
+		BindPassword:      "service",
+		BaseDN:            "ou=people,dc=example,dc=com",
+		UserFilter:        "(|(uid=%s)(mail=%s))",
+		UsernameAttribute: "uid",
+		EmailAttribute:    "mail",
+		GroupAttribute:    "memberOf",
+		GroupRoles: ParseGroupRoles(
+			"admin=" + ldapAdminsDN + ";user=" + ldapStaffDN,
+		),
+	}, db, hasher)
+
+	// local accounts sharing an email or a username with directory entries
+	localAdmin := &domain.User{Username: "eve-local", Email: "eve@example.com", Password: "x", Role: domain.AdminRole}
+	localUser := &domain.User{Username: "mallory", Email: "mallory@local.test", Password: "x", Role: domain.UserRole}
+	for _, u := range []*domain.User{localAdmin, localUser} {
+		if err := db.Create(u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ada, err := verifier.Verify("ada", "ada-pw")
+	if err != nil {
+		t.Fatalf("Verify(ada) returned an error: %v", err)
+	}
+	if ada.Role != domain.AdminRole || ada.AuthProvider != LDAPBackend || ada.Firstname != "Ada" {
+		t.Errorf("provisioned %+v", ada)
+	}
+	if _, err := verifier.Verify("ada", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Verify with a wrong password = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := verifier.Verify("bob", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Verify with an empty password = %v, want ErrInvalidCredentials", err)
+	}
+
+	// the directory can't take over accounts it didn't create
+	if _, err := verifier.Verify("eve", "eve-pw"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Verify(eve) = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := verifier.Verify("mallory", "mallory-pw"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Verify(mallory) = %v, want ErrInvalidCredentials", err)
+	}
+	for _, u := range []*domain.User{localAdmin, localUser} {
+		stored := &domain.User{}
+		db.First(stored, u.ID)
+		if stored.Role != u.Role || stored.AuthProvider == LDAPBackend {
+			t.Errorf("local account %s changed to %+v", u.Username, stored)
+		}
+	}
+
+	// a role changed here sticks until the directory's groups change
+	bob, err := verifier.Verify("bob", "bob-pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Model(bob).Update("role", domain.GuestRole)
+	if bob, _ = verifier.Verify("bob", "bob-pw"); bob.Role != domain.GuestRole {
+		t.Errorf("role after sign-in = %s, want the guest role set locally", bob.Role)
+	}
+	directory.set(ldapPerson("bob", "bob-pw", ldapAdminsDN))
+	if bob, _ = verifier.Verify("bob", "bob-pw"); bob.Role != domain.AdminRole {
+		t.Errorf("role after group change = %s, want admin", bob.Role)
+	}
+
+	// the directory can demote admins, but not the last one
+	db.Model(localAdmin).Update("role", domain.UserRole)
+	directory.set(ldapPerson("ada", "ada-pw", ldapStaffDN))
+	if ada, err = verifier.Verify("ada", "ada-pw"); err != nil || ada.Role != domain.UserRole {
+		t.Errorf("Verify(ada) after leaving the admins = %+v, %v, want a user", ada, err)
+	}
+	directory.set(ldapPerson("bob", "bob-pw", ldapStaffDN))
+	if _, err := verifier.Verify("bob", "bob-pw"); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("Verify(bob) as the last admin leaving the admins = %v, want ErrLastAdmin", err)
+	}
+	stored := &domain.User{}
+	db.First(stored, bob.ID)
+	if stored.Role != domain.AdminRole {
+		t.Errorf("last admin's role = %s, want admin", stored.Role)
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+type LockoutConfig struct {
+	// Threshold is the number of consecutive failures that lock an account.
+	// Zero disables lockouts.
+	Threshold int
+	// BaseDuration is the first lockout, doubled for every lockout since
+	// the last successful sign-in, up to MaxDuration.
+	BaseDuration time.Duration
+	MaxDuration  time.Duration
+}
+
+func NewLockoutConfig(env *domain.Env) LockoutConfig {
+	return LockoutConfig{
+		Threshold:    int(env.LockoutThreshold),
+		BaseDuration: time.Duration(env.LockoutBaseMinutes) * time.Minute,
+		MaxDuration:  time.Duration(env.LockoutMaxMinutes) * time.Minute,
+	}
+}
+
+type LockoutService interface {
+	// Locked reports whether the account behind identifier is locked.
+	// Unknown identifiers are never locked.
+	Locked(identifier string) (bool, error)
+	// Fail counts a failed sign-in against identifier. It returns the user
+	// and true when this failure locked the account.
+	Fail(identifier string) (*domain.User, bool, error)
+	// Succeed resets the user's failure and lockout counters.
+	Succeed(user *domain.User) error
+	Unlock(userID uint) error
+}
+
+type lockoutService struct {
+	cfg LockoutConfig
+	db  *gorm.DB
+}
+
+func NewLockoutService(cfg LockoutConfig, db *gorm.DB) LockoutService {
+	return &lockoutService{cfg: cfg, db: db}
+}
+
+func (s *lockoutService) Locked(identifier string) (bool, error) {
+	if s.cfg.Threshold <= 0 {
+		return false, nil
+	}
+	var count int64
+	err := s.db.Model(&domain.User{}).
+		Where("(email = ? OR username = ?) AND locked_until > ?", identifier, identifier, time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (s *lockoutService) Fail(identifier string) (*domain.User, bool, error) {
+	if s.cfg.Threshold <= 0 {
+		return nil, false, nil
+	}
+	user := &domain.User{}
+	locked := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("email = ? OR username = ?", identifier, identifier).
+			First(user).Error
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		user.FailedLoginAttempts++
+		user.LastFailedLoginAt = &now
+		if user.FailedLoginAttempts >= s.cfg.Threshold {
+			until := now.Add(s.backoff(user.LockoutCount))
+			user.LockedUntil = &until
+			user.LockoutCount++
+			user.FailedLoginAttempts = 0
+			locked = true
+		}
+		return tx.Model(user).Select(
+			"failed_login_attempts",
+			"last_failed_login_at",
+			"locked_until",
+			"lockout_count",
+		).Updates(user).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return user, locked, nil
+}
+
+func (s *lockoutService) Succeed(user *domain.User) error {
+	if user.FailedLoginAttempts == 0 && user.LockoutCount == 0 {
+		return nil
+	}
+	return s.Unlock(user.ID)
+}
+
+func (s *lockoutService) Unlock(userID uint) error {
+	return s.db.Model(&domain.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+		"lockout_count":         0,
+	}).Error
+}
+
+// backoff is BaseDuration doubled for each earlier lockout, capped at
+// MaxDuration.
+func (s *lockoutService) backoff(lockouts int) time.Duration {
+	d := s.cfg.BaseDuration
+	for i := 0; i < lockouts && d < s.cfg.MaxDuration; i++ {
+		d *= 2
+	}
+	if s.cfg.MaxDuration > 0 && d > s.cfg.MaxDuration {
+		d = s.cfg.MaxDuration
+	}
+	return d
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func TestLockoutBackoff(t *testing.T) {
+	s := &lockoutService{cfg: LockoutConfig{
+		Threshold:    3,
+		BaseDuration: time.Minute,
+		MaxDuration:  10 * time.Minute,
+	}}
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for lockouts, d := range want {
+		if got := s.backoff(lockouts); got != d {
+			t.Errorf("backoff(%d) = %s, want %s", lockouts, got, d)
+		}
+	}
+}
+
+func TestLockoutService(t *testing.T) {
+	db := dbtest.New(t)
+	s := NewLockoutService(LockoutConfig{
+		Threshold:    3,
+		BaseDuration: time.Minute,
+		MaxDuration:  time.Hour,
+	}, db)
+	user := &domain.User{Username: "ada", Email: "ada@example.com", Password: "x", Role: domain.UserRole}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	fail := func(identifier string) bool {
+		t.Helper()
+		_, locked, err := s.Fail(identifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return locked
+	}
+	locked := func(identifier string) bool {
+		t.Helper()
+		locked, err := s.Locked(identifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return locked
+	}
+	stored := func() *domain.User {
+		u := &domain.User{}
+		db.First(u, user.ID)
+		return u
+	}
+
+	if fail("ada") || fail("ada@example.com") || locked("ada") {
+		t.Fatal("locked before the threshold")
+	}
+	if !fail("ada") {
+		t.Fatal("the third failure didn't lock the account")
+	}
+	if !locked("ada") || !locked("ada@example.com") {
+		t.Error("Locked doesn't report the lockout")
+	}
+	u := stored()
+	if u.FailedLoginAttempts != 0 || u.LockoutCount != 1 || u.LockedUntil == nil ||
+		u.LockedUntil.Sub(time.Now()) > time.Minute {
+		t.Errorf("after the first lockout %+v", u)
+	}
+
+	// the next lockout lasts twice as long
+	db.Model(user).Update("locked_until", time.Now().Add(-time.Second))
+	if locked("ada") {
+		t.Error("still locked after the lockout ended")
+	}
+	fail("ada")
+	fail("ada")
+	if !fail("ada") {
+		t.Fatal("the sixth failure didn't lock the account")
+	}
+	if u = stored(); u.LockoutCount != 2 || u.LockedUntil.Sub(time.Now()) < time.Minute {
+		t.Errorf("after the second lockout %+v", u)
+	}
+
+	if err := s.Unlock(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if u = stored(); locked("ada") || u.LockoutCount != 0 || u.FailedLoginAttempts != 0 {
+		t.Errorf("after unlock %+v", u)
+	}
+
+	fail("ada")
+	if err := s.Succeed(stored()); err != nil {
+		t.Fatal(err)
+	}
+	if u = stored(); u.FailedLoginAttempts != 0 {
+		t.Errorf("a successful sign-in left %d failures", u.FailedLoginAttempts)
+	}
+
+	if user, locked, err := s.Fail("nobody"); user != nil || locked || err != nil {
+		t.Errorf("Fail for an unknown identifier = %v, %v, %v", user, locked, err)
+	}
+	disabled := NewLockoutService(LockoutConfig{}, db)
+	for i := 0; i < 5; i++ {
+		disabled.Fail("ada")
+	}
+	if locked, _ := disabled.Locked("ada"); locked || stored().FailedLoginAttempts != 0 {
+		t.Error("a zero threshold still counts failures")
+	}
+}
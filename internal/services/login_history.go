@@ -0,0 +1,134 @@
+package services
+
+import (
+	"net"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+// loginKeyset lists sign-in attempts newest first.
+var loginKeyset = util.Keyset{Sort: "-created_at", Column: "created_at", Desc: true, Time: true}
+
+type LoginHistoryService interface {
+	// Record stores attempt. For a successful sign-in it also reports
+	// whether the user agent or IP range is new for the account. The first
+	// successful sign-in of an account is never reported as new.
+	Record(attempt *domain.LoginAttempt) (newDevice bool, err error)
+	// List returns a page of at most limit attempts on the account of
+	// userID, newest first, starting after cursor or from the newest attempt
+	// when cursor is nil.
+	List(userID uint, cursor *util.Cursor, limit int) ([]domain.LoginAttempt, *domain.PageMeta, error)
+	// Purge deletes the attempts made before before.
+	Purge(before time.Time) (int64, error)
+}
+
+type loginHistoryService struct {
+	db *gorm.DB
+}
+
+func NewLoginHistoryService(db *gorm.DB) LoginHistoryService {
+	return &loginHistoryService{db: db}
+}
+
+func (s *loginHistoryService) Record(attempt *domain.LoginAttempt) (bool, error) {
+	attempt.IPRange = IPRange(attempt.IP)
+	if attempt.UserID == nil && attempt.Identifier != "" {
+		user := domain.User{}
+		err := s.db.Select("id").
+			Where("email = ? OR username = ?", attempt.Identifier, attempt.Identifier).
+			Limit(1).
+			Find(&user).Error
+		if err != nil {
+			return false, err
+		}
+		if user.ID != 0 {
+			attempt.UserID = &user.ID
+		}
+	}
+	// whatever was typed for an unknown account may be a mistyped password
+	if attempt.UserID == nil {
+		attempt.Identifier = ""
+	}
+
+	newDevice := false
+	if attempt.Outcome == domain.LoginSucceeded && attempt.UserID != nil {
+		seen := func(conds ...interface{}) (bool, error) {
+			var count int64
+			q := s.db.Model(&domain.LoginAttempt{}).
+				Where("user_id = ? AND outcome = ?", *attempt.UserID, domain.LoginSucceeded)
+			if len(conds) > 0 {
+				q = q.Where(conds[0], conds[1:]...)
+			}
+			err := q.Count(&count).Error
+			return count > 0, err
+		}
+		known, err := seen()
+		if err != nil {
+			return false, err
+		}
+		knownAgent, err := seen("user_agent = ?", attempt.UserAgent)
+		if err != nil {
+			return false, err
+		}
+		knownRange, err := seen("ip_range = ?", attempt.IPRange)
+		if err != nil {
+			return false, err
+		}
+		newDevice = known && (!knownAgent || !knownRange)
+	}
+	return newDevice, s.db.Create(attempt).Error
+}
+
+func (s *loginHistoryService) List(
+	userID uint,
+	cursor *util.Cursor,
+	limit int,
+) ([]domain.LoginAttempt, *domain.PageMeta, error) {
+	meta := &domain.PageMeta{Limit: limit}
+	err := s.db.Model(&domain.LoginAttempt{}).Where("user_id = ?", userID).Count(&meta.Total).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	var attempts []domain.LoginAttempt
+	query := s.db.Where("user_id = ?", userID).Order(loginKeyset.Order())
+	if cursor != nil {
+		if query, err = loginKeyset.Seek(s.db.Where("user_id = ?", userID), cursor); err != nil {
+			return nil, nil, err
+		}
+	}
+	// one extra row tells whether there is another page
+	if err := query.Limit(limit + 1).Find(&attempts).Error; err != nil {
+		return nil, nil, err
+	}
+	attempts, meta.NextCursor, meta.PrevCursor = util.KeysetPage(
+		attempts,
+		limit,
+		cursor,
+		func(attempt *domain.LoginAttempt, before bool) *util.Cursor {
+			return loginKeyset.TimeCursor(attempt.CreatedAt, attempt.ID, before)
+		},
+	)
+	return attempts, meta, nil
+}
+
+func (s *loginHistoryService) Purge(before time.Time) (int64, error) {
+	res := s.db.Where("created_at < ?", before).Delete(&domain.LoginAttempt{})
+	return res.RowsAffected, res.Error
+}
+
+// IPRange is the /24 network of an IPv4 address or the /48 of an IPv6 one,
+// roughly what a home or office connection moves around in.
+func IPRange(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+func TestLoginHistory(t *testing.T) {
+	db := dbtest.New(t)
+	s := NewLoginHistoryService(db)
+	user := &domain.User{Username: "ada", Email: "ada@example.com", Password: "x", Role: domain.UserRole}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	record := func(identifier string, outcome domain.LoginOutcome, ip, agent string) (*domain.LoginAttempt, bool) {
+		t.Helper()
+		attempt := &domain.LoginAttempt{Identifier: identifier, Outcome: outcome, IP: ip, UserAgent: agent}
+		newDevice, err := s.Record(attempt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return attempt, newDevice
+	}
+
+	if a, _ := record("correct horse", domain.LoginInvalidCredentials, "198.51.100.1", "curl"); a.UserID != nil || a.Identifier != "" {
+		t.Errorf("unknown identifier stored as %+v", a)
+	}
+	if a, _ := record("ada", domain.LoginInvalidCredentials, "203.0.113.1", "firefox"); a.UserID == nil || *a.UserID != user.ID || a.Identifier != "ada" {
+		t.Errorf("failure for a known username stored as %+v", a)
+	}
+
+	steps := []struct {
+		name  string
+		ip    string
+		agent string
+		want  bool
+	}{
+		{"first sign-in", "198.51.100.1", "firefox", false},
+		{"same device", "198.51.100.1", "firefox", false},
+		{"same network", "198.51.100.99", "firefox", false},
+		{"new agent", "198.51.100.1", "safari", true},
+		{"new network", "203.0.113.1", "firefox", true},
+	}
+	for _, step := range steps {
+		if _, got := record("ada@example.com", domain.LoginSucceeded, step.ip, step.agent); got != step.want {
+			t.Errorf("%s: new device = %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	attempts, meta, err := s.List(user.ID, nil, 3)
+	if err != nil || len(attempts) != 3 || attempts[0].IP != "203.0.113.1" {
+		t.Fatalf("List = %+v, %v, want the newest 3", attempts, err)
+	}
+	if meta.Total != 6 || meta.NextCursor == "" || meta.PrevCursor != "" {
+		t.Errorf("first page meta = %+v", meta)
+	}
+	cursor, _ := util.DecodeCursor(meta.NextCursor)
+	older, meta, err := s.List(user.ID, cursor, 3)
+	if err != nil || len(older) != 3 || older[0].ID >= attempts[2].ID || meta.NextCursor != "" {
+		t.Errorf("second page = %+v, %+v, %v, want the 3 oldest", older, meta, err)
+	}
+
+	db.Model(&domain.LoginAttempt{}).Where("ip = ?", "198.51.100.1").
+		Update("created_at", time.Now().AddDate(0, 0, -100))
+	n, err := s.Purge(time.Now().AddDate(0, 0, -90))
+	if err != nil || n != 4 {
+		t.Errorf("Purge = %d, %v, want the 4 old attempts", n, err)
+	}
+	var left int64
+	db.Model(&domain.LoginAttempt{}).Count(&left)
+	if left != 3 {
+		t.Errorf("%d attempts left, want 3", left)
+	}
+}
+
+func TestIPRange(t *testing.T) {
+	tests := map[string]string{
+		"198.51.100.42":     "198.51.100.0/24",
+		"2001:db8:1:2::1":   "2001:db8:1::/48",
+		"::ffff:192.0.2.10": "192.0.2.0/24",
+		"not an ip":         "not an ip",
+	}
+	for ip, want := range tests {
+		if got := IPRange(ip); got != want {
+			t.Errorf("IPRange(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+// networkPolicyTTL bounds how long another instance's rule changes take to
+// apply here.
+const networkPolicyTTL = 30 * time.Second
+
+type NetworkPolicy interface {
+	// Allowed reports whether ip may be used by a user with role. An empty
+	// role checks only the global rules.
+	Allowed(ip string, role domain.Role) bool
+	// Rules returns the configured rules followed by the stored ones.
+	Rules() ([]domain.NetworkRule, error)
+	// Reload drops the cached rules after a change.
+	Reload()
+}
+
+type networkPolicy struct {
+	db     *gorm.DB
+	static []domain.NetworkRule
+
+	mu       sync.Mutex
+	rules    []domain.NetworkRule
+	loadedAt time.Time
+}
+
+// NewNetworkPolicy combines the CIDR lists from the environment with the
+// rules stored in the database.
+func NewNetworkPolicy(env *domain.Env, db *gorm.DB) (NetworkPolicy, error) {
+	var static []domain.NetworkRule
+	add := func(cidrs []string, action domain.NetworkAction, role domain.Role) error {
+		for _, cidr := range cidrs {
+			cidr = strings.TrimSpace(cidr)
+			if cidr == "" {
+				continue
+			}
+			if _, err := ParseCIDR(cidr); err != nil {
+				return err
+			}
+			static = append(static, domain.NetworkRule{CIDR: cidr, Action: action, Role: role})
+		}
+		return nil
+	}
+	if err := add(env.NetworkAllow, domain.NetworkAllow, ""); err != nil {
+		return nil, err
+	}
+	if err := add(env.NetworkDeny, domain.NetworkDeny, ""); err != nil {
+		return nil, err
+	}
+	if err := add(env.AdminNetworkAllow, domain.NetworkAllow, domain.AdminRole); err != nil {
+		return nil, err
+	}
+	return &networkPolicy{db: db, static: static}, nil
+}
+
+func (p *networkPolicy) Allowed(ip string, role domain.Role) bool {
+	rules, err := p.Rules()
+	if err != nil {
+		// fall back to the configured rules rather than locking everyone out
+		rules = p.static
+	}
+	return NetworkAllowed(rules, ip, role)
+}
+
+func (p *networkPolicy) Rules() ([]domain.NetworkRule, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rules != nil && time.Since(p.loadedAt) < networkPolicyTTL {
+		return p.rules, nil
+	}
+	var stored []domain.NetworkRule
+	if err := p.db.Order("id").Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	p.rules = append(append([]domain.NetworkRule{}, p.static...), stored...)
+	p.loadedAt = time.Now()
+	return p.rules, nil
+}
+
+func (p *networkPolicy) Reload() {
+	p.mu.Lock()
+	p.rules = nil
+	p.mu.Unlock()
+}
+
+// NetworkAllowed applies rules to ip for role. Deny rules win. Each scope,
+// global and the role's, that has allow rules requires ip to match one of
+// them.
+func NetworkAllowed(rules []domain.NetworkRule, ip string, role domain.Role) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	var globalAllow, roleAllow, inGlobal, inRole bool
+	for _, rule := range rules {
+		if rule.Role != "" && rule.Role != role {
+			continue
+		}
+		network, err := ParseCIDR(rule.CIDR)
+		if err != nil {
+			continue
+		}
+		match := network.Contains(addr)
+		switch rule.Action {
+		case domain.NetworkDeny:
+			if match {
+				return false
+			}
+		case domain.NetworkAllow:
+			if rule.Role == "" {
+				globalAllow = true
+				inGlobal = inGlobal || match
+			} else {
+				roleAllow = true
+				inRole = inRole || match
+			}
+		}
+	}
+	return (!globalAllow || inGlobal) && (!roleAllow || inRole)
+}
+
+// ParseCIDR also accepts a bare address as a single host range.
+func ParseCIDR(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", cidr)
+	}
+	return network, nil
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func TestNetworkAllowed(t *testing.T) {
+	rules := []domain.NetworkRule{
+		{CIDR: "203.0.113.7", Action: domain.NetworkDeny},
+		{CIDR: "10.0.0.0/8", Action: domain.NetworkAllow, Role: domain.AdminRole},
+	}
+	tests := []struct {
+		ip   string
+		role domain.Role
+		want bool
+	}{
+		{"198.51.100.1", domain.UserRole, true},
+		{"203.0.113.7", domain.UserRole, false},
+		{"10.1.2.3", domain.AdminRole, true},
+		{"198.51.100.1", domain.AdminRole, false},
+		{"not an ip", domain.UserRole, false},
+	}
+	for _, tt := range tests {
+		if got := NetworkAllowed(rules, tt.ip, tt.role); got != tt.want {
+			t.Errorf("NetworkAllowed(%s, %s) = %v, want %v", tt.ip, tt.role, got, tt.want)
+		}
+	}
+
+	rules = append(rules, domain.NetworkRule{CIDR: "2001:db8::/32", Action: domain.NetworkAllow})
+	if NetworkAllowed(rules, "198.51.100.1", domain.UserRole) {
+		t.Error("a global allow rule should refuse addresses outside it")
+	}
+	if !NetworkAllowed(rules, "2001:db8::1", domain.UserRole) {
+		t.Error("address inside the global allow rule should be allowed")
+	}
+}
@@ -0,0 +1,375 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+const (
+	Argon2idAlgorithm = "argon2id"
+	BcryptAlgorithm   = "bcrypt"
+)
+
+// pepperedBcryptPrefix marks bcrypt hashes of a peppered password. Bcrypt has
+// no room for extra parameters, so the key ID is kept in a wrapper:
+// $bcrypt-pepper$keyid=2$2b$12$...
+const pepperedBcryptPrefix = "$bcrypt-pepper$"
+
+// maxCostFactor bounds the cost of stored hashes to this multiple of the
+// configured cost, so that an imported hash can't make every Verify
+// allocate gigabytes or run for minutes.
+const maxCostFactor = 4
+
+// minArgon2Length and maxArgon2Length bound the salt and key length of
+// stored hashes. A short key would match wrong passwords by chance, and an
+// empty one makes argon2 panic.
+const (
+	minArgon2Length = 16
+	maxArgon2Length = 64
+)
+
+// defaultArgon2Params stands in for the limits when no argon2 parameters are
+// configured.
+var defaultArgon2Params = Argon2Params{Memory: 65536, Iterations: 3, Parallelism: 2}
+
+var (
+	ErrUnknownHashFormat = errors.New("unknown password hash format")
+	ErrUnknownPepper     = errors.New("password hash uses an unknown pepper key")
+	ErrHashTooCostly     = errors.New("password hash parameters exceed the configured limits")
+)
+
+// PasswordHasher hashes passwords into self-describing strings so that
+// hashes made with older algorithms or parameters keep verifying.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded and, if it does,
+	// whether encoded should be replaced with a fresh Hash.
+	Verify(password, encoded string) (ok bool, rehash bool, err error)
+	// Dummy spends as long as Verify on a hash no password matches, so that
+	// requests for unknown accounts take as long as those for real ones.
+	Dummy(password string)
+	// IsSupportedHash reports whether encoded is in a format Verify
+	// understands, with a cost within the configured limits.
+	IsSupportedHash(encoded string) bool
+}
+
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type PasswordHasherConfig struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+	// Peppers holds HMAC keys by ID. Old keys must stay configured until no
+	// hash refers to them. PepperID selects the key for new hashes; when it
+	// is empty passwords are hashed without a pepper.
+	Peppers  map[string][]byte
+	PepperID string
+}
+
+func NewPasswordHasherConfig(env *domain.Env) (PasswordHasherConfig, error) {
+	peppers, err := ParsePepperKeys(env.PasswordPepperKeys)
+	if err != nil {
+		return PasswordHasherConfig{}, err
+	}
+	if _, ok := peppers[env.PasswordPepperKeyID]; env.PasswordPepperKeyID != "" && !ok {
+		return PasswordHasherConfig{}, fmt.Errorf("pepper key %q is not configured", env.PasswordPepperKeyID)
+	}
+	return PasswordHasherConfig{
+		Algorithm: env.PasswordHashAlgorithm,
+		Argon2: Argon2Params{
+			Memory:      env.Argon2MemoryKiB,
+			Iterations:  env.Argon2Iterations,
+			Parallelism: env.Argon2Parallelism,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+		BcryptCost: env.BcryptCost,
+		Peppers:    peppers,
+		PepperID:   env.PasswordPepperKeyID,
+	}, nil
+}
+
+// ParsePepperKeys parses "id=base64key" pairs separated by semicolons.
+func ParsePepperKeys(s string) (map[string][]byte, error) {
+	peppers := make(map[string][]byte)
+	for _, pair := range strings.Split(s, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, "=")
+		if !ok || id == "" || strings.ContainsAny(id, "$,") {
+			return nil, fmt.Errorf("malformed pepper key %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) < 32 {
+			return nil, fmt.Errorf("pepper key %q must be at least 32 base64 encoded bytes", id)
+		}
+		peppers[id] = key
+	}
+	return peppers, nil
+}
+
+type passwordHasher struct {
+	cfg       PasswordHasherConfig
+	dummyOnce sync.Once
+	dummy     string
+}
+
+func NewPasswordHasher(cfg PasswordHasherConfig) PasswordHasher {
+	return &passwordHasher{cfg: cfg}
+}
+
+func (h *passwordHasher) Hash(password string) (string, error) {
+	secret, err := h.pepper(password, h.cfg.PepperID)
+	if err != nil {
+		return "", err
+	}
+	if h.cfg.Algorithm == BcryptAlgorithm {
+		hash, err := bcrypt.GenerateFromPassword(secret, h.cfg.BcryptCost)
+		if err != nil || h.cfg.PepperID == "" {
+			return string(hash), err
+		}
+		return pepperedBcryptPrefix + "keyid=" + h.cfg.PepperID + string(hash), nil
+	}
+	salt := make([]byte, h.cfg.Argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := h.cfg.Argon2
+	key := argon2.IDKey(secret, salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return encodeArgon2id(p, h.cfg.PepperID, salt, key), nil
+}
+
+func (h *passwordHasher) Verify(password, encoded string) (bool, bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		p, keyID, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		if !h.argon2WithinLimits(p) {
+			return false, false, ErrHashTooCostly
+		}
+		secret, err := h.pepper(password, keyID)
+		if err != nil {
+			return false, false, err
+		}
+		other := argon2.IDKey(secret, salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+		want := h.cfg.Argon2
+		rehash := h.cfg.Algorithm == BcryptAlgorithm ||
+			keyID != h.cfg.PepperID ||
+			p.Memory != want.Memory ||
+			p.Iterations != want.Iterations ||
+			p.Parallelism != want.Parallelism ||
+			p.KeyLength != want.KeyLength
+		return true, rehash, nil
+	case isBcrypt(encoded) || strings.HasPrefix(encoded, pepperedBcryptPrefix):
+		keyID, hash, err := splitPepperedBcrypt(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return false, false, err
+		}
+		if cost > h.maxBcryptCost() {
+			return false, false, ErrHashTooCostly
+		}
+		secret, err := h.pepper(password, keyID)
+		if err != nil {
+			return false, false, err
+		}
+		err = bcrypt.CompareHashAndPassword([]byte(hash), secret)
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		rehash := h.cfg.Algorithm != BcryptAlgorithm ||
+			keyID != h.cfg.PepperID ||
+			cost != h.cfg.BcryptCost
+		return true, rehash, nil
+	default:
+		return false, false, ErrUnknownHashFormat
+	}
+}
+
+func (h *passwordHasher) Dummy(password string) {
+	h.dummyOnce.Do(func() {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		h.dummy, _ = h.Hash(base64.RawStdEncoding.EncodeToString(secret))
+	})
+	h.Verify(password, h.dummy)
+}
+
+// pepper returns the bytes that actually get hashed. With a key the password
+// is replaced by its base64 HMAC-SHA256, which also keeps it within bcrypt's
+// 72 byte limit.
+func (h *passwordHasher) pepper(password, keyID string) ([]byte, error) {
+	if keyID == "" {
+		return []byte(password), nil
+	}
+	key, ok := h.cfg.Peppers[keyID]
+	if !ok {
+		return nil, ErrUnknownPepper
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	return []byte(base64.RawStdEncoding.EncodeToString(mac.Sum(nil))), nil
+}
+
+func (h *passwordHasher) IsSupportedHash(encoded string) bool {
+	if isBcrypt(encoded) || strings.HasPrefix(encoded, pepperedBcryptPrefix) {
+		_, hash, err := splitPepperedBcrypt(encoded)
+		if err != nil {
+			return false
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err == nil && cost <= h.maxBcryptCost()
+	}
+	p, _, _, _, err := decodeArgon2id(encoded)
+	return err == nil && h.argon2WithinLimits(p)
+}
+
+// argon2WithinLimits reports whether hashing with p costs at most
+// maxCostFactor times the configured parameters.
+func (h *passwordHasher) argon2WithinLimits(p Argon2Params) bool {
+	limit := h.cfg.Argon2
+	if limit.Memory == 0 || limit.Iterations == 0 || limit.Parallelism == 0 {
+		limit = defaultArgon2Params
+	}
+	return uint64(p.Memory) <= maxCostFactor*uint64(limit.Memory) &&
+		uint64(p.Iterations) <= maxCostFactor*uint64(limit.Iterations) &&
+		uint64(p.Parallelism) <= maxCostFactor*uint64(limit.Parallelism) &&
+		p.SaltLength <= maxArgon2Length &&
+		p.KeyLength <= maxArgon2Length
+}
+
+// maxBcryptCost is the highest bcrypt cost Verify accepts. The cost is a
+// power of two, so two above the configured cost is maxCostFactor times the
+// work.
+func (h *passwordHasher) maxBcryptCost() int {
+	cost := h.cfg.BcryptCost
+	if cost < bcrypt.MinCost {
+		cost = bcrypt.DefaultCost
+	}
+	return cost + 2
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+// splitPepperedBcrypt returns the pepper key ID and the plain bcrypt hash.
+// Hashes without the wrapper have an empty key ID.
+func splitPepperedBcrypt(encoded string) (string, string, error) {
+	rest, ok := strings.CutPrefix(encoded, pepperedBcryptPrefix+"keyid=")
+	if !ok {
+		return "", encoded, nil
+	}
+	i := strings.Index(rest, "$")
+	if i <= 0 || !isBcrypt(rest[i:]) {
+		return "", "", ErrUnknownHashFormat
+	}
+	return rest[:i], rest[i:], nil
+}
+
+// encodeArgon2id renders the PHC string format,
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>. Peppered hashes carry the
+// key ID as the PHC keyid parameter, e.g. m=65536,t=3,p=2,keyid=2.
+func encodeArgon2id(p Argon2Params, keyID string, salt, key []byte) string {
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
+	if keyID != "" {
+		params += ",keyid=" + keyID
+	}
+	return fmt.Sprintf(
+		"$argon2id$v=%d$%s$%s$%s",
+		argon2.Version,
+		params,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, string, []byte, []byte, error) {
+	var p Argon2Params
+	var keyID string
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != Argon2idAlgorithm {
+		return p, "", nil, nil, ErrUnknownHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, "", nil, nil, ErrUnknownHashFormat
+	}
+	if version != argon2.Version {
+		return p, "", nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		var err error
+		var n uint64
+		switch name {
+		case "m":
+			n, err = strconv.ParseUint(value, 10, 32)
+			p.Memory = uint32(n)
+		case "t":
+			n, err = strconv.ParseUint(value, 10, 32)
+			p.Iterations = uint32(n)
+		case "p":
+			n, err = strconv.ParseUint(value, 10, 8)
+			p.Parallelism = uint8(n)
+		case "keyid":
+			keyID = value
+		default:
+			err = ErrUnknownHashFormat
+		}
+		if err != nil {
+			return p, "", nil, nil, ErrUnknownHashFormat
+		}
+	}
+	if p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+		return p, "", nil, nil, ErrUnknownHashFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, "", nil, nil, ErrUnknownHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, "", nil, nil, ErrUnknownHashFormat
+	}
+	if len(salt) < minArgon2Length || len(key) < minArgon2Length {
+		return p, "", nil, nil, ErrUnknownHashFormat
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, keyID, salt, key, nil
+}
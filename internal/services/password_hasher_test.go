@@ -0,0 +1,179 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testHasherConfig() PasswordHasherConfig {
+	return PasswordHasherConfig{
+		Algorithm: Argon2idAlgorithm,
+		Argon2: Argon2Params{
+			Memory:      1024,
+			Iterations:  1,
+			Parallelism: 1,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+		BcryptCost: bcrypt.MinCost,
+	}
+}
+
+func TestPasswordHasherArgon2id(t *testing.T) {
+	hasher := NewPasswordHasher(testHasherConfig())
+	hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("error hashing password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("hash is not in PHC format: %s", hash)
+	}
+
+	ok, rehash, err := hasher.Verify("correct horse", hash)
+	if err != nil || !ok || rehash {
+		t.Errorf("Verify(correct) = %v, %v, %v", ok, rehash, err)
+	}
+	ok, _, err = hasher.Verify("battery staple", hash)
+	if err != nil || ok {
+		t.Errorf("Verify(wrong) = %v, %v", ok, err)
+	}
+
+	cfg := testHasherConfig()
+	cfg.Argon2.Iterations = 2
+	_, rehash, _ = NewPasswordHasher(cfg).Verify("correct horse", hash)
+	if !rehash {
+		t.Error("hash with old parameters should be flagged for rehash")
+	}
+}
+
+func TestPasswordHasherLegacyBcrypt(t *testing.T) {
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	hasher := NewPasswordHasher(testHasherConfig())
+
+	ok, rehash, err := hasher.Verify("correct horse", string(legacy))
+	if err != nil || !ok || !rehash {
+		t.Errorf("Verify(bcrypt) = %v, %v, %v", ok, rehash, err)
+	}
+	if _, _, err := hasher.Verify("correct horse", "plaintext"); err != ErrUnknownHashFormat {
+		t.Errorf("expected ErrUnknownHashFormat, got %v", err)
+	}
+}
+
+func TestPasswordHasherPepperRotation(t *testing.T) {
+	cfg := testHasherConfig()
+	cfg.Peppers = map[string][]byte{
+		"1": []byte(strings.Repeat("a", 32)),
+		"2": []byte(strings.Repeat("b", 32)),
+	}
+	cfg.PepperID = "1"
+	old, err := NewPasswordHasher(cfg).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("error hashing password: %v", err)
+	}
+	if !strings.Contains(old, ",keyid=1$") {
+		t.Fatalf("hash does not record the pepper key: %s", old)
+	}
+
+	cfg.PepperID = "2"
+	hasher := NewPasswordHasher(cfg)
+	ok, rehash, err := hasher.Verify("correct horse", old)
+	if err != nil || !ok || !rehash {
+		t.Errorf("Verify(old pepper) = %v, %v, %v", ok, rehash, err)
+	}
+
+	cfg.Algorithm = BcryptAlgorithm
+	hash, err := NewPasswordHasher(cfg).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("error hashing password: %v", err)
+	}
+	if !NewPasswordHasher(cfg).IsSupportedHash(hash) {
+		t.Errorf("peppered bcrypt hash not recognised: %s", hash)
+	}
+	ok, rehash, err = NewPasswordHasher(cfg).Verify("correct horse", hash)
+	if err != nil || !ok || rehash {
+		t.Errorf("Verify(bcrypt pepper) = %v, %v, %v", ok, rehash, err)
+	}
+
+	delete(cfg.Peppers, "1")
+	if _, _, err := hasher.Verify("correct horse", old); err != ErrUnknownPepper {
+		t.Errorf("expected ErrUnknownPepper, got %v", err)
+	}
+}
+
+func TestPasswordHasherShortArgon2id(t *testing.T) {
+	hasher := NewPasswordHasher(testHasherConfig())
+	params := Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+	salt := []byte(strings.Repeat("s", 16))
+	key := []byte(strings.Repeat("k", 32))
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"empty key", encodeArgon2id(params, "", salt, nil)},
+		{"one byte key", encodeArgon2id(params, "", salt, []byte{'k'})},
+		{"short key", encodeArgon2id(params, "", salt, key[:15])},
+		{"empty salt", encodeArgon2id(params, "", nil, key)},
+		{"short salt", encodeArgon2id(params, "", salt[:8], key)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if hasher.IsSupportedHash(tt.encoded) {
+				t.Errorf("IsSupportedHash(%s) = true", tt.encoded)
+			}
+			if ok, _, err := hasher.Verify("correct horse", tt.encoded); ok || !errors.Is(err, ErrUnknownHashFormat) {
+				t.Errorf("Verify(%s) = %v, %v, want ErrUnknownHashFormat", tt.encoded, ok, err)
+			}
+		})
+	}
+}
+
+func TestPasswordHasherCostLimits(t *testing.T) {
+	hasher := NewPasswordHasher(testHasherConfig())
+	salt := []byte(strings.Repeat("s", 16))
+	key := []byte(strings.Repeat("k", 32))
+	argon := func(p Argon2Params) string {
+		return encodeArgon2id(p, "", salt, key)
+	}
+	bcryptHash := func(cost int) string {
+		hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// only the cost field is read before the limit check
+		return strings.Replace(string(hash), fmt.Sprintf("$%02d$", bcrypt.MinCost), fmt.Sprintf("$%02d$", cost), 1)
+	}
+	tests := []struct {
+		name    string
+		encoded string
+		ok      bool
+	}{
+		{"argon2id at the limit", argon(Argon2Params{Memory: 4096, Iterations: 4, Parallelism: 4}), true},
+		{"argon2id memory", argon(Argon2Params{Memory: 4097, Iterations: 1, Parallelism: 1}), false},
+		{"argon2id iterations", argon(Argon2Params{Memory: 1024, Iterations: 5, Parallelism: 1}), false},
+		{"argon2id parallelism", argon(Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 5}), false},
+		{"argon2id maximum memory", argon(Argon2Params{Memory: 4294967295, Iterations: 1, Parallelism: 1}), false},
+		{"argon2id long key", encodeArgon2id(
+			Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}, "", salt, make([]byte, 65),
+		), false},
+		{"bcrypt at the limit", bcryptHash(bcrypt.MinCost + 2), true},
+		{"bcrypt above the limit", bcryptHash(bcrypt.MinCost + 3), false},
+		{"bcrypt maximum cost", bcryptHash(bcrypt.MaxCost), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasher.IsSupportedHash(tt.encoded); got != tt.ok {
+				t.Errorf("IsSupportedHash(%s) = %v, want %v", tt.encoded, got, tt.ok)
+			}
+			if tt.ok {
+				return
+			}
+			if _, _, err := hasher.Verify("correct horse", tt.encoded); !errors.Is(err, ErrHashTooCostly) {
+				t.Errorf("Verify(%s) = %v, want ErrHashTooCostly", tt.encoded, err)
+			}
+		})
+	}
+}
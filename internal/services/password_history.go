@@ -0,0 +1,82 @@
+package services
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+)
+
+var ErrPasswordReused = errors.New(helper.ErrPasswordReused)
+
+type PasswordHistoryService interface {
+	// Check returns ErrPasswordReused when password matches the user's
+	// current password or one of the last N recorded ones.
+	Check(user *domain.User, password string) error
+	// Record stores a newly set hash and prunes entries beyond the last N.
+	Record(userID uint, hash string) error
+}
+
+type passwordHistoryService struct {
+	db     *gorm.DB
+	hasher PasswordHasher
+	size   int
+}
+
+// NewPasswordHistoryService keeps the last size passwords. A size of zero
+// disables the history.
+func NewPasswordHistoryService(db *gorm.DB, hasher PasswordHasher, size int) PasswordHistoryService {
+	return &passwordHistoryService{db: db, hasher: hasher, size: size}
+}
+
+func (s *passwordHistoryService) Check(user *domain.User, password string) error {
+	if s.size <= 0 {
+		return nil
+	}
+	var history []domain.PasswordHistory
+	err := s.db.Where("user_id = ?", user.ID).
+		Order("created_at desc, id desc").
+		Limit(s.size).
+		Find(&history).Error
+	if err != nil {
+		return err
+	}
+	hashes := []string{user.Password}
+	for _, h := range history {
+		hashes = append(hashes, h.Hash)
+	}
+	for _, hash := range hashes {
+		if hash == "" {
+			continue
+		}
+		ok, _, err := s.hasher.Verify(password, hash)
+		if err != nil && !errors.Is(err, ErrUnknownHashFormat) {
+			return err
+		}
+		if ok {
+			return ErrPasswordReused
+		}
+	}
+	return nil
+}
+
+func (s *passwordHistoryService) Record(userID uint, hash string) error {
+	if s.size <= 0 {
+		return nil
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		entry := &domain.PasswordHistory{UserID: userID, Hash: hash}
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+		keep := tx.Model(&domain.PasswordHistory{}).
+			Select("id").
+			Where("user_id = ?", userID).
+			Order("created_at desc, id desc").
+			Limit(s.size)
+		return tx.Where("user_id = ? AND id NOT IN (?)", userID, keep).
+			Delete(&domain.PasswordHistory{}).Error
+	})
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleUpper     = "uppercase"
+	RuleLower     = "lowercase"
+	RuleDigit     = "digit"
+	RuleSymbol    = "symbol"
+	RuleIdentity  = "identity"
+	RuleBreached  = "breached"
+)
+
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type PasswordPolicy interface {
+	// Validate returns one violation per broken rule, or nil if password is
+	// acceptable for user.
+	Validate(password string, user *domain.User) []PolicyViolation
+}
+
+type PasswordPolicyConfig struct {
+	MinLength        int
+	MaxLength        int
+	RequireUpper     bool
+	RequireLower     bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	DisallowIdentity bool
+	// BreachedFile lists SHA-1 hashes of known breached passwords, one hex
+	// digest per line. Lines in the "HASH:count" format are accepted.
+	BreachedFile string
+}
+
+func NewPasswordPolicyConfig(env *domain.Env) PasswordPolicyConfig {
+	return PasswordPolicyConfig{
+		MinLength:        env.PasswordMinLength,
+		MaxLength:        env.PasswordMaxLength,
+		RequireUpper:     env.PasswordRequireUpper,
+		RequireLower:     env.PasswordRequireLower,
+		RequireDigit:     env.PasswordRequireDigit,
+		RequireSymbol:    env.PasswordRequireSymbol,
+		DisallowIdentity: env.PasswordDisallowIdentity,
+		BreachedFile:     env.BreachedPasswordsFile,
+	}
+}
+
+type passwordPolicy struct {
+	cfg      PasswordPolicyConfig
+	breached map[[sha1.Size]byte]struct{}
+}
+
+func NewPasswordPolicy(cfg PasswordPolicyConfig) (PasswordPolicy, error) {
+	p := &passwordPolicy{cfg: cfg}
+	if cfg.BreachedFile != "" {
+		breached, err := loadBreachedCorpus(cfg.BreachedFile)
+		if err != nil {
+			return nil, err
+		}
+		p.breached = breached
+	}
+	return p, nil
+}
+
+func (p *passwordPolicy) Validate(password string, user *domain.User) []PolicyViolation {
+	var violations []PolicyViolation
+	length := utf8.RuneCountInString(password)
+	if length < p.cfg.MinLength {
+		violations = append(violations, PolicyViolation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("must be at least %d characters", p.cfg.MinLength),
+		})
+	}
+	if p.cfg.MaxLength > 0 && length > p.cfg.MaxLength {
+		violations = append(violations, PolicyViolation{
+			Rule:    RuleMaxLength,
+			Message: fmt.Sprintf("must be at most %d characters", p.cfg.MaxLength),
+		})
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.cfg.RequireUpper && !upper {
+		violations = append(violations, PolicyViolation{
+			Rule:    RuleUpper,
+			Message: "must contain an uppercase letter",
+		})
+	}
+	if p.cfg.RequireLower && !lower {
+		violations = append(violations, PolicyViolation{
+			Rule:    RuleLower,
+			Message: "must contain a lowercase letter",
+		})
+	}
+	if p.cfg.RequireDigit && !digit {
+		violations = append(violations, PolicyViolation{
+			Rule:    RuleDigit,
+			Message: "must contain a digit",
+		})
+	}
+	if p.cfg.RequireSymbol && !symbol {
+		violations = append(violations, PolicyViolation{
+			Rule:    RuleSymbol,
+			Message: "must contain a symbol",
+		})
+	}
+
+	if p.cfg.DisallowIdentity && user != nil && containsIdentity(password, user) {
+		violations = append(violations, PolicyViolation{
+			Rule:    RuleIdentity,
+			Message: "must not contain your username or email",
+		})
+	}
+
+	if p.breached != nil {
+		if _, ok := p.breached[sha1.Sum([]byte(password))]; ok {
+			violations = append(violations, PolicyViolation{
+				Rule:    RuleBreached,
+				Message: "has appeared in a data breach, choose another",
+			})
+		}
+	}
+	return violations
+}
+
+// containsIdentity reports whether password contains the username or the
+// local part of the email. Very short identifiers are ignored so that a
+// username like "a" does not reject every password containing that letter.
+func containsIdentity(password string, user *domain.User) bool {
+	lowered := strings.ToLower(password)
+	local, _, _ := strings.Cut(user.Email, "@")
+	for _, id := range []string{user.Username, local} {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if len(id) >= 3 && strings.Contains(lowered, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadBreachedCorpus(path string) (map[[sha1.Size]byte]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open breached password corpus: %w", err)
+	}
+	defer f.Close()
+
+	corpus := make(map[[sha1.Size]byte]struct{})
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		digest, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if digest == "" || strings.HasPrefix(digest, "#") {
+			continue
+		}
+		var key [sha1.Size]byte
+		if len(digest) != hex.EncodedLen(sha1.Size) {
+			return nil, fmt.Errorf("%s:%d: not a sha1 hex digest", path, line)
+		}
+		if _, err := hex.Decode(key[:], []byte(digest)); err != nil {
+			return nil, fmt.Errorf("%s:%d: not a sha1 hex digest", path, line)
+		}
+		corpus[key] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return corpus, nil
+}
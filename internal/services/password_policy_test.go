@@ -0,0 +1,61 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func rules(violations []PolicyViolation) map[string]bool {
+	m := make(map[string]bool)
+	for _, v := range violations {
+		m[v.Rule] = true
+	}
+	return m
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	// sha1("password123")
+	corpus := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(corpus, []byte("CBFDAC6008F9CAB4083784CBD1874F76618D2A97:2446\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewPasswordPolicy(PasswordPolicyConfig{
+		MinLength:        8,
+		MaxLength:        72,
+		RequireUpper:     true,
+		RequireDigit:     true,
+		DisallowIdentity: true,
+		BreachedFile:     corpus,
+	})
+	if err != nil {
+		t.Fatalf("error creating policy: %v", err)
+	}
+	user := &domain.User{Username: "onion", Email: "layers@example.com"}
+
+	tests := []struct {
+		password string
+		want     []string
+	}{
+		{"", []string{RuleMinLength, RuleUpper, RuleDigit}},
+		{"Correct4Horse", nil},
+		{"MyOnion99", []string{RuleIdentity}},
+		{"Layers4Days", []string{RuleIdentity}},
+		{"password123", []string{RuleUpper, RuleBreached}},
+	}
+	for _, tt := range tests {
+		got := rules(policy.Validate(tt.password, user))
+		if len(got) != len(tt.want) {
+			t.Errorf("Validate(%q) = %v, want %v", tt.password, got, tt.want)
+			continue
+		}
+		for _, rule := range tt.want {
+			if !got[rule] {
+				t.Errorf("Validate(%q) missing %s violation", tt.password, rule)
+			}
+		}
+	}
+}
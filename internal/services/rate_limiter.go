@@ -0,0 +1,194 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+const (
+	MemoryRateLimitStore   = "memory"
+	PostgresRateLimitStore = "postgres"
+)
+
+// RateLimit is a token bucket holding up to Burst tokens that refills
+// completely over Window.
+type RateLimit struct {
+	Burst  int
+	Window time.Duration
+}
+
+func (l RateLimit) perSecond() float64 {
+	return float64(l.Burst) / l.Window.Seconds()
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next token is available, zero when
+	// the request was allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// RateLimitStore keeps token buckets by key. Take removes a token from the
+// bucket for key if there is one, Peek reports what Take would without
+// removing anything.
+type RateLimitStore interface {
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+	Peek(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+func NewRateLimitStore(kind string, db *gorm.DB) (RateLimitStore, error) {
+	switch kind {
+	case "", MemoryRateLimitStore:
+		return NewMemoryRateLimitStore(), nil
+	case PostgresRateLimitStore:
+		return NewPostgresRateLimitStore(db), nil
+	default:
+		return nil, errors.New("unknown rate limit store " + kind)
+	}
+}
+
+// take refills a bucket last seen at updated with tokens in it and removes
+// one token if possible. It returns the new token count with the result.
+func take(tokens float64, updated, now time.Time, limit RateLimit) (float64, RateLimitResult) {
+	rate := limit.perSecond()
+	tokens = math.Min(float64(limit.Burst), tokens+now.Sub(updated).Seconds()*rate)
+	result := RateLimitResult{}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	result.Remaining = int(tokens)
+	result.Reset = time.Duration((float64(limit.Burst) - tokens) / rate * float64(time.Second))
+	return tokens, result
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	window  time.Duration
+}
+
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryRateLimitStore keeps buckets in process. Limits are per instance,
+// so use the Postgres store when running more than one.
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{
+		buckets: make(map[string]*memoryBucket),
+		now:     time.Now,
+	}
+}
+
+func (s *memoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	var result RateLimitResult
+	b.tokens, result = take(b.tokens, b.updated, now, limit)
+	b.updated = now
+	b.window = limit.Window
+	return result, nil
+}
+
+func (s *memoryRateLimitStore) Peek(key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(limit.Burst), updated: now}
+	}
+	_, result := take(b.tokens, b.updated, now, limit)
+	return result, nil
+}
+
+// sweep drops buckets that have had time to refill, they are
+// indistinguishable from new ones.
+func (s *memoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) > b.window {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+type postgresRateLimitStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresRateLimitStore shares buckets between instances through the
+// rate_limit_buckets table.
+func NewPostgresRateLimitStore(db *gorm.DB) RateLimitStore {
+	return &postgresRateLimitStore{db: db}
+}
+
+func (s *postgresRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	var result RateLimitResult
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		b := domain.RateLimitBucket{
+			Key:       key,
+			Tokens:    float64(limit.Burst),
+			UpdatedAt: now,
+			ExpiresAt: now.Add(limit.Window),
+		}
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&b).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("key = ?", key).
+			First(&b).Error
+		if err != nil {
+			return err
+		}
+		b.Tokens, result = take(b.Tokens, b.UpdatedAt, now, limit)
+		b.UpdatedAt = now
+		b.ExpiresAt = now.Add(result.Reset)
+		return tx.Save(&b).Error
+	})
+	return result, err
+}
+
+func (s *postgresRateLimitStore) Peek(key string, limit RateLimit) (RateLimitResult, error) {
+	now := time.Now()
+	var buckets []domain.RateLimitBucket
+	if err := s.db.Where("key = ?", key).Limit(1).Find(&buckets).Error; err != nil {
+		return RateLimitResult{}, err
+	}
+	b := domain.RateLimitBucket{Tokens: float64(limit.Burst), UpdatedAt: now}
+	if len(buckets) > 0 {
+		b = buckets[0]
+	}
+	_, result := take(b.Tokens, b.UpdatedAt, now, limit)
+	return result, nil
+}
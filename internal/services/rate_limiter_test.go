@@ -0,0 +1,33 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryRateLimitStore().(*memoryRateLimitStore)
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Burst: 3, Window: 3 * time.Minute}
+
+	for i := 2; i >= 0; i-- {
+		result, _ := store.Take("ip:1.2.3.4", limit)
+		if !result.Allowed || result.Remaining != i {
+			t.Fatalf("request %d: got %+v", 3-i, result)
+		}
+	}
+	result, _ := store.Take("ip:1.2.3.4", limit)
+	if result.Allowed || result.RetryAfter != time.Minute {
+		t.Fatalf("expected denial with a minute to wait, got %+v", result)
+	}
+	if result, _ := store.Take("ip:5.6.7.8", limit); !result.Allowed {
+		t.Fatal("buckets should be independent")
+	}
+
+	now = now.Add(time.Minute)
+	result, _ = store.Take("ip:1.2.3.4", limit)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("expected one refilled token, got %+v", result)
+	}
+}
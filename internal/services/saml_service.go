@@ -0,0 +1,296 @@
+package services
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+const SAMLProvider = "saml"
+
+var ErrSAMLNotConfigured = errors.New("saml service provider key pair is not configured")
+
+type SAMLService interface {
+	// ServiceProvider builds the SP for an enabled connection.
+	ServiceProvider(conn *domain.SAMLConnection) (*saml.ServiceProvider, error)
+	// ValidateConnection checks the IdP metadata and pinned certificate.
+	ValidateConnection(conn *domain.SAMLConnection) error
+	// Provision finds or creates the user asserted by the IdP. Existing
+	// accounts are only signed in when they belong to conn.
+	Provision(conn *domain.SAMLConnection, assertion *saml.Assertion) (*domain.User, error)
+	// Link lets conn sign in an account created some other way.
+	Link(conn *domain.SAMLConnection, user *domain.User) error
+}
+
+type samlService struct {
+	db        *gorm.DB
+	hasher    PasswordHasher
+	publicURL string
+	key       *rsa.PrivateKey
+	cert      *x509.Certificate
+}
+
+// NewSAMLService loads the SP signing key pair shared by every connection.
+// Without one the service still starts but SAML endpoints report
+// ErrSAMLNotConfigured.
+func NewSAMLService(env *domain.Env, db *gorm.DB, hasher PasswordHasher) (SAMLService, error) {
+	s := &samlService{
+		db:        db,
+		hasher:    hasher,
+		publicURL: strings.TrimRight(env.PublicURL, "/"),
+	}
+	if env.SAMLCertFile == "" || env.SAMLKeyFile == "" {
+		return s, nil
+	}
+	keyPair, err := tls.LoadX509KeyPair(env.SAMLCertFile, env.SAMLKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load saml key pair: %w", err)
+	}
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("saml key must be an RSA private key")
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse saml certificate: %w", err)
+	}
+	s.key, s.cert = key, cert
+	return s, nil
+}
+
+func (s *samlService) ServiceProvider(conn *domain.SAMLConnection) (*saml.ServiceProvider, error) {
+	if s.key == nil {
+		return nil, ErrSAMLNotConfigured
+	}
+	idp, err := samlsp.ParseMetadata([]byte(conn.IDPMetadataXML))
+	if err != nil {
+		return nil, fmt.Errorf("parse idp metadata: %w", err)
+	}
+	if conn.IDPCertificate != "" {
+		if err := pinIDPCertificate(idp, conn.IDPCertificate); err != nil {
+			return nil, err
+		}
+	}
+	base := fmt.Sprintf("%s/auth/saml/%s", s.publicURL, url.PathEscape(conn.Slug))
+	metadataURL, err := url.Parse(base + "/metadata")
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(base + "/acs")
+	if err != nil {
+		return nil, err
+	}
+	return &saml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		Key:               s.key,
+		Certificate:       s.cert,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       idp,
+		AllowIDPInitiated: conn.AllowIDPInitiated,
+	}, nil
+}
+
+func (s *samlService) ValidateConnection(conn *domain.SAMLConnection) error {
+	idp, err := samlsp.ParseMetadata([]byte(conn.IDPMetadataXML))
+	if err != nil {
+		return fmt.Errorf("parse idp metadata: %w", err)
+	}
+	if len(idp.IDPSSODescriptors) == 0 {
+		return errors.New("idp metadata has no IDPSSODescriptor")
+	}
+	if strings.TrimSpace(conn.EmailDomains) == "" {
+		return errors.New("email_domains is required")
+	}
+	if conn.DefaultRole != "" && !isSAMLRole(conn.DefaultRole) {
+		return fmt.Errorf("default_role can't be %s", conn.DefaultRole)
+	}
+	if conn.IDPCertificate != "" {
+		return pinIDPCertificate(idp, conn.IDPCertificate)
+	}
+	return nil
+}
+
+func (s *samlService) Provision(
+	conn *domain.SAMLConnection,
+	assertion *saml.Assertion,
+) (*domain.User, error) {
+	email := samlAttribute(assertion, conn.EmailAttribute)
+	if email == "" && assertion.Subject != nil && assertion.Subject.NameID != nil &&
+		assertion.Subject.NameID.Format == string(saml.EmailAddressNameIDFormat) {
+		email = assertion.Subject.NameID.Value
+	}
+	if email == "" {
+		return nil, fmt.Errorf("assertion is missing the %q attribute", conn.EmailAttribute)
+	}
+	if !emailInDomains(email, conn.EmailDomains) {
+		return nil, fmt.Errorf("connection %s may not assert %s", conn.Slug, email)
+	}
+
+	user := &domain.User{}
+	err := s.db.Unscoped().Where("email = ?", email).First(user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		// accounts are only signed in by the connection that created them
+		// or that an admin linked them to
+		if user.DeletedAt.Valid || user.SAMLConnectionID == nil || *user.SAMLConnectionID != conn.ID {
+			return nil, fmt.Errorf("connection %s may not sign in %s, the account isn't linked to it",
+				conn.Slug, email)
+		}
+		if !isSAMLRole(user.Role) {
+			return nil, fmt.Errorf("connection %s may not sign in %s, the account has the %s role",
+				conn.Slug, email, user.Role)
+		}
+	} else {
+		username := samlAttribute(assertion, conn.UsernameAttribute)
+		if username == "" {
+			username = email
+		}
+		var taken int64
+		err := s.db.Unscoped().Model(&domain.User{}).Where("username = ?", username).Count(&taken).Error
+		if err != nil {
+			return nil, err
+		}
+		if taken > 0 {
+			return nil, fmt.Errorf("connection %s may not sign in %s, username %s is taken",
+				conn.Slug, email, username)
+		}
+		// the IdP owns the password, store something nobody knows
+		secret, err := util.GenerateSecret(32)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := s.hasher.Hash(secret)
+		if err != nil {
+			return nil, err
+		}
+		user = &domain.User{
+			Email:            email,
+			Username:         username,
+			Password:         hash,
+			AuthProvider:     SAMLProvider,
+			SAMLConnectionID: &conn.ID,
+			IsEmailVerified:  true,
+			VerifiedAt:       time.Now(),
+		}
+	}
+	if v := samlAttribute(assertion, conn.FirstnameAttribute); v != "" {
+		user.Firstname = v
+	}
+	if v := samlAttribute(assertion, conn.LastnameAttribute); v != "" {
+		user.Lastname = v
+	}
+	// a customer IdP can never assert its way into our admin or service
+	// roles. The role follows the IdP when it changes, so a role set here in
+	// between is kept until then.
+	role := conn.DefaultRole
+	if role == "" {
+		role = domain.UserRole
+	}
+	if v := domain.Role(samlAttribute(assertion, conn.RoleAttribute)); isSAMLRole(v) {
+		role = v
+	}
+	if user.ProviderRole != role {
+		user.Role = role
+		user.ProviderRole = role
+	}
+	if err := s.db.Save(user).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *samlService) Link(conn *domain.SAMLConnection, user *domain.User) error {
+	if !emailInDomains(user.Email, conn.EmailDomains) {
+		return fmt.Errorf("connection %s may not sign in %s", conn.Slug, user.Email)
+	}
+	if !isSAMLRole(user.Role) {
+		return fmt.Errorf("accounts with the %s role can't use single sign-on", user.Role)
+	}
+	// the IdP's role replaces the current one at the first sign-in
+	return s.db.Model(user).Updates(map[string]interface{}{
+		"auth_provider":      SAMLProvider,
+		"saml_connection_id": conn.ID,
+		"provider_role":      "",
+	}).Error
+}
+
+// isSAMLRole reports whether role may be held by an account signing in
+// through a customer IdP.
+func isSAMLRole(role domain.Role) bool {
+	_, ok := domain.Roles[role]
+	return ok && role != domain.AdminRole && role != domain.ServiceRole
+}
+
+// samlAttribute returns the first value of the attribute matching name by
+// either its Name or FriendlyName.
+func samlAttribute(assertion *saml.Assertion, name string) string {
+	if name == "" {
+		return ""
+	}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if (attr.Name == name || attr.FriendlyName == name) && len(attr.Values) > 0 {
+				return attr.Values[0].Value
+			}
+		}
+	}
+	return ""
+}
+
+// emailInDomains reports whether email belongs to one of the comma
+// separated domains. An empty list allows none.
+func emailInDomains(email, domains string) bool {
+	_, host, ok := strings.Cut(email, "@")
+	if !ok || host == "" {
+		return false
+	}
+	for _, d := range strings.Split(domains, ",") {
+		if strings.EqualFold(strings.TrimSpace(d), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// pinIDPCertificate replaces the signing keys advertised in the IdP
+// metadata with the certificate configured on the connection.
+func pinIDPCertificate(idp *saml.EntityDescriptor, certPEM string) error {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return errors.New("idp certificate is not valid PEM")
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return fmt.Errorf("parse idp certificate: %w", err)
+	}
+	key := saml.KeyDescriptor{
+		Use: "signing",
+		KeyInfo: saml.KeyInfo{
+			X509Data: saml.X509Data{
+				X509Certificates: []saml.X509Certificate{
+					{Data: base64.StdEncoding.EncodeToString(block.Bytes)},
+				},
+			},
+		},
+	}
+	for i := range idp.IDPSSODescriptors {
+		idp.IDPSSODescriptors[i].KeyDescriptors = []saml.KeyDescriptor{key}
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"encoding/xml"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func samlTestKeyPair(t *testing.T, name string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+// samlTestIdP signs responses the way a customer IdP would.
+type samlTestIdP struct {
+	idp *saml.IdentityProvider
+}
+
+func newSAMLTestIdP(t *testing.T) *samlTestIdP {
+	key, cert := samlTestKeyPair(t, "idp.example.com")
+	metadataURL, _ := url.Parse("https://idp.example.com/metadata")
+	ssoURL, _ := url.Parse("https://idp.example.com/sso")
+	return &samlTestIdP{idp: &saml.IdentityProvider{
+		Key:         key,
+		Certificate: cert,
+		MetadataURL: *metadataURL,
+		SSOURL:      *ssoURL,
+	}}
+}
+
+func (p *samlTestIdP) metadata(t *testing.T) string {
+	buf, err := xml.Marshal(p.idp.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+// respond returns the IdP initiated ACS request asserting email and attrs.
+func (p *samlTestIdP) respond(
+	t *testing.T,
+	sp *saml.ServiceProvider,
+	email string,
+	attrs ...saml.Attribute,
+) *http.Request {
+	t.Helper()
+	metadata := sp.Metadata()
+	req := &saml.IdpAuthnRequest{
+		IDP:                     p.idp,
+		HTTPRequest:             httptest.NewRequest(http.MethodGet, p.idp.SSOURL.String(), nil),
+		ServiceProviderMetadata: metadata,
+		SPSSODescriptor:         &metadata.SPSSODescriptors[0],
+		ACSEndpoint:             &metadata.SPSSODescriptors[0].AssertionConsumerServices[0],
+		Now:                     saml.TimeNow(),
+	}
+	session := &saml.Session{
+		ID:               "session",
+		CreateTime:       req.Now,
+		ExpireTime:       req.Now.Add(time.Hour),
+		Index:            "1",
+		NameID:           email,
+		NameIDFormat:     string(saml.EmailAddressNameIDFormat),
+		UserEmail:        email,
+		CustomAttributes: attrs,
+	}
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, session); err != nil {
+		t.Fatal(err)
+	}
+	form, err := req.PostBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := url.Values{"SAMLResponse": {form.SAMLResponse}}.Encode()
+	r := httptest.NewRequest(http.MethodPost, form.URL, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := r.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func samlAttr(name, value string) saml.Attribute {
+	return saml.Attribute{Name: name, Values: []saml.AttributeValue{{Type: "xs:string", Value: value}}}
+}
+
+func newTestSAMLService(t *testing.T, db *gorm.DB) SAMLService {
+	t.Helper()
+	key, cert := samlTestKeyPair(t, "sp.example.com")
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "sp.crt")
+	keyFile := filepath.Join(dir, "sp.key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	hasher := NewPasswordHasher(PasswordHasherConfig{Algorithm: BcryptAlgorithm, BcryptCost: bcrypt.MinCost})
+	s, err := NewSAMLService(&domain.Env{
+		PublicURL:    "https://auth.example.com",
+		SAMLCertFile: certFile,
+		SAMLKeyFile:  keyFile,
+	}, db, hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSAMLProvision(t *testing.T) {
+	db := dbtest.New(t)
+	service := newTestSAMLService(t, db)
+	idp := newSAMLTestIdP(t)
+
+	conn := &domain.SAMLConnection{
+		Slug:               "acme",
+		IDPMetadataXML:     idp.metadata(t),
+		EmailDomains:       "acme.test",
+		EmailAttribute:     "email",
+		FirstnameAttribute: "firstName",
+		RoleAttribute:      "role",
+		DefaultRole:        domain.UserRole,
+		AllowIDPInitiated:  true,
+		Enabled:            true,
+	}
+	other := &domain.SAMLConnection{
+		Slug:              "other",
+		IDPMetadataXML:    idp.metadata(t),
+		EmailDomains:      "acme.test",
+		EmailAttribute:    "email",
+		DefaultRole:       domain.UserRole,
+		AllowIDPInitiated: true,
+		Enabled:           true,
+	}
+	for _, c := range []*domain.SAMLConnection{conn, other} {
+		if err := service.ValidateConnection(c); err != nil {
+			t.Fatalf("ValidateConnection(%s) returned an error: %v", c.Slug, err)
+		}
+		if err := db.Create(c).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	sp, err := service.ServiceProvider(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signIn := func(conn *domain.SAMLConnection, email string, attrs ...saml.Attribute) (*domain.User, error) {
+		t.Helper()
+		assertion, err := sp.ParseResponse(idp.respond(t, sp, email, append(attrs, samlAttr("email", email))...), nil)
+		if err != nil {
+			if invalid, ok := err.(*saml.InvalidResponseError); ok {
+				err = invalid.PrivateErr
+			}
+			t.Fatalf("ParseResponse(%s) returned an error: %v", email, err)
+		}
+		return service.Provision(conn, assertion)
+	}
+
+	// first sign-in creates the account, the IdP can't make it an admin
+	ada, err := signIn(conn, "ada@acme.test", samlAttr("firstName", "Ada"), samlAttr("role", "admin"))
+	if err != nil {
+		t.Fatalf("Provision(ada) returned an error: %v", err)
+	}
+	if ada.Role != domain.UserRole || ada.AuthProvider != SAMLProvider || ada.Firstname != "Ada" ||
+		ada.SAMLConnectionID == nil || *ada.SAMLConnectionID != conn.ID {
+		t.Errorf("provisioned %+v", ada)
+	}
+	if ada, _ = signIn(conn, "ada@acme.test", samlAttr("role", "service")); ada.Role != domain.UserRole {
+		t.Errorf("role after asserting service = %s, want user", ada.Role)
+	}
+
+	// a role set here is kept until the IdP's role changes
+	db.Model(ada).Update("role", domain.GuestRole)
+	if ada, _ = signIn(conn, "ada@acme.test"); ada.Role != domain.GuestRole {
+		t.Errorf("role after sign-in = %s, want the guest role set locally", ada.Role)
+	}
+	if ada, _ = signIn(conn, "ada@acme.test", samlAttr("role", "guest")); ada.Role != domain.GuestRole {
+		t.Errorf("role after the IdP asserted guest = %s, want guest", ada.Role)
+	}
+
+	if _, err := signIn(conn, "ada@elsewhere.test"); err == nil {
+		t.Error("Provision signed in an email outside the connection's domains")
+	}
+	if _, err := signIn(other, "ada@acme.test"); err == nil {
+		t.Error("Provision signed in an account created by another connection")
+	}
+
+	// existing accounts need the explicit link step, admins can't be linked
+	bob := &domain.User{Username: "bob", Email: "bob@acme.test", Password: "x", Role: domain.UserRole}
+	root := &domain.User{Username: "root", Email: "root@acme.test", Password: "x", Role: domain.AdminRole}
+	for _, u := range []*domain.User{bob, root} {
+		if err := db.Create(u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := signIn(conn, "bob@acme.test"); err == nil {
+		t.Error("Provision signed in a local account that wasn't linked")
+	}
+	if _, err := signIn(conn, "root@acme.test"); err == nil {
+		t.Error("Provision signed in a local admin")
+	}
+	if err := service.Link(conn, root); err == nil {
+		t.Error("Link accepted an admin")
+	}
+	if err := service.Link(conn, bob); err != nil {
+		t.Fatalf("Link(bob) returned an error: %v", err)
+	}
+	if bob, err = signIn(conn, "bob@acme.test"); err != nil {
+		t.Fatalf("Provision(bob) after Link returned an error: %v", err)
+	}
+	if bob.ID == 0 || bob.Username != "bob" || bob.AuthProvider != SAMLProvider {
+		t.Errorf("linked %+v", bob)
+	}
+
+	// a linked account promoted to admin stops signing in through the IdP
+	db.Model(bob).Updates(map[string]interface{}{"role": domain.AdminRole, "provider_role": domain.AdminRole})
+	if _, err := signIn(conn, "bob@acme.test"); err == nil {
+		t.Error("Provision signed in an account holding the admin role")
+	}
+
+	// a username taken by another account isn't a server error
+	conn.UsernameAttribute = "uid"
+	if _, err := signIn(conn, "dave@acme.test", samlAttr("uid", "bob")); err == nil {
+		t.Error("Provision created an account with a taken username")
+	}
+}
+
+func TestSAMLForgedResponse(t *testing.T) {
+	db := dbtest.New(t)
+	service := newTestSAMLService(t, db)
+	idp, forger := newSAMLTestIdP(t), newSAMLTestIdP(t)
+	conn := &domain.SAMLConnection{
+		Slug:              "acme",
+		IDPMetadataXML:    idp.metadata(t),
+		EmailDomains:      "acme.test",
+		EmailAttribute:    "email",
+		AllowIDPInitiated: true,
+	}
+	sp, err := service.ServiceProvider(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.ParseResponse(forger.respond(t, sp, "ada@acme.test"), nil); err == nil {
+		t.Error("ParseResponse accepted a response signed by another key")
+	}
+}
+
+func TestSAMLValidateConnection(t *testing.T) {
+	service := newTestSAMLService(t, dbtest.New(t))
+	metadata := newSAMLTestIdP(t).metadata(t)
+	tests := []struct {
+		name string
+		conn domain.SAMLConnection
+		ok   bool
+	}{
+		{"valid", domain.SAMLConnection{IDPMetadataXML: metadata, EmailDomains: "acme.test"}, true},
+		{"no email domains", domain.SAMLConnection{IDPMetadataXML: metadata}, false},
+		{"admin default role", domain.SAMLConnection{
+			IDPMetadataXML: metadata, EmailDomains: "acme.test", DefaultRole: domain.AdminRole,
+		}, false},
+		{"service default role", domain.SAMLConnection{
+			IDPMetadataXML: metadata, EmailDomains: "acme.test", DefaultRole: domain.ServiceRole,
+		}, false},
+		{"bad metadata", domain.SAMLConnection{IDPMetadataXML: "<x/>", EmailDomains: "acme.test"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := service.ValidateConnection(&tt.conn)
+			if (err == nil) != tt.ok {
+				t.Errorf("ValidateConnection = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestEmailInDomains(t *testing.T) {
+	tests := []struct {
+		email, domains string
+		want           bool
+	}{
+		{"ada@acme.test", "acme.test", true},
+		{"ada@ACME.test", "other.test, acme.test", true},
+		{"ada@acme.test.evil", "acme.test", false},
+		{"ada@acme.test", "", false},
+		{"ada@", "", false},
+		{"ada", "acme.test", false},
+	}
+	for _, tt := range tests {
+		if got := emailInDomains(tt.email, tt.domains); got != tt.want {
+			t.Errorf("emailInDomains(%q, %q) = %v, want %v", tt.email, tt.domains, got, tt.want)
+		}
+	}
+}
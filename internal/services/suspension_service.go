@@ -0,0 +1,136 @@
+package services
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+type SuspensionService interface {
+	// Suspend blocks the user until until, or until lifted when until is
+	// nil. by is the admin responsible. It returns ErrLastAdmin rather than
+	// suspend the last admin able to administer.
+	Suspend(user *domain.User, reason string, until *time.Time, by uint) error
+	Unsuspend(user *domain.User) error
+	// Access looks up, in one query, what decides whether an access token
+	// of the user with userID for the session sessionID is still good.
+	Access(userID, sessionID uint) (*Access, error)
+	// LiftExpired clears the suspensions that have run out and returns how
+	// many there were.
+	LiftExpired() (int64, error)
+}
+
+// Access is the state of an account as seen by an access token.
+type Access struct {
+	// Found is false once the account has been deleted.
+	Found bool
+	// Session is true when the session is a live one of the account.
+	Session   bool
+	Suspended bool
+}
+
+type suspensionService struct {
+	db     *gorm.DB
+	admins AdminGuard
+}
+
+func NewSuspensionService(db *gorm.DB) SuspensionService {
+	return &suspensionService{db: db, admins: NewAdminGuard()}
+}
+
+func (s *suspensionService) Suspend(
+	user *domain.User,
+	reason string,
+	until *time.Time,
+	by uint,
+) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.admins.Check(tx, user.ID); err != nil {
+			return err
+		}
+		now := time.Now()
+		user.SuspendedAt = &now
+		user.SuspendedUntil = until
+		user.SuspensionReason = reason
+		user.SuspendedByID = &by
+		return tx.Model(user).Select(
+			"suspended_at",
+			"suspended_until",
+			"suspension_reason",
+			"suspended_by_id",
+		).Updates(user).Error
+	})
+}
+
+func (s *suspensionService) Unsuspend(user *domain.User) error {
+	user.SuspendedAt = nil
+	user.SuspendedUntil = nil
+	user.SuspensionReason = ""
+	user.SuspendedByID = nil
+	return s.db.Model(user).Updates(suspensionCleared).Error
+}
+
+func (s *suspensionService) Access(userID, sessionID uint) (*Access, error) {
+	var row struct {
+		SuspendedAt    *time.Time
+		SuspendedUntil *time.Time
+		SessionID      *uint
+	}
+	result := s.db.Model(&domain.User{}).
+		Select("users.suspended_at, users.suspended_until, tokens.id AS session_id").
+		Joins(
+			"LEFT JOIN tokens ON tokens.id = ? AND tokens.user_id = users.id "+
+				"AND tokens.type = ? AND tokens.deleted_at IS NULL",
+			sessionID,
+			domain.REFRESH,
+		).
+		Where("users.id = ?", userID).
+		Limit(1).
+		Scan(&row)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	user := &domain.User{SuspendedAt: row.SuspendedAt, SuspendedUntil: row.SuspendedUntil}
+	return &Access{
+		Found:     result.RowsAffected > 0,
+		Session:   row.SessionID != nil,
+		Suspended: IsSuspended(user, time.Now()),
+	}, nil
+}
+
+func (s *suspensionService) LiftExpired() (int64, error) {
+	result := s.db.Model(&domain.User{}).
+		Where("suspended_at IS NOT NULL AND suspended_until <= ?", time.Now()).
+		Updates(suspensionCleared)
+	return result.RowsAffected, result.Error
+}
+
+// IsSuspended reports whether user is suspended at now.
+func IsSuspended(user *domain.User, now time.Time) bool {
+	return user.SuspendedAt != nil && (user.SuspendedUntil == nil || now.Before(*user.SuspendedUntil))
+}
+
+var suspensionCleared = map[string]interface{}{
+	"suspended_at":      nil,
+	"suspended_until":   nil,
+	"suspension_reason": "",
+	"suspended_by_id":   nil,
+}
+
+const suspendedCondition = "suspended_at IS NOT NULL AND (suspended_until IS NULL OR suspended_until > ?)"
+
+// suspended limits a users query to the ones suspended at now.
+func suspended(now time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(suspendedCondition, now)
+	}
+}
+
+// notSuspended limits a users query to the ones not suspended at now.
+func notSuspended(now time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("NOT ("+suspendedCondition+")", now)
+	}
+}
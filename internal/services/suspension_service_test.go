@@ -0,0 +1,122 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func TestIsSuspended(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	tests := []struct {
+		name string
+		user domain.User
+		want bool
+	}{
+		{"never", domain.User{}, false},
+		{"until lifted", domain.User{SuspendedAt: &past}, true},
+		{"until later", domain.User{SuspendedAt: &past, SuspendedUntil: &future}, true},
+		{"ran out", domain.User{SuspendedAt: &past, SuspendedUntil: &past}, false},
+		{"runs out now", domain.User{SuspendedAt: &past, SuspendedUntil: &now}, false},
+	}
+	for _, tt := range tests {
+		if got := IsSuspended(&tt.user, now); got != tt.want {
+			t.Errorf("%s: IsSuspended = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSuspensionService(t *testing.T) {
+	db := dbtest.New(t)
+	s := NewSuspensionService(db)
+	var users []*domain.User
+	for _, name := range []string{"ada", "bob", "cy"} {
+		user := &domain.User{Username: name, Email: name + "@example.com", Password: "x"}
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	ada, bob, cy := users[0], users[1], users[2]
+	session := &domain.Token{UserID: ada.ID, Type: domain.REFRESH, Hash: "session"}
+	code := &domain.Token{UserID: ada.ID, Type: domain.RESET_PASSWORD, Hash: "code"}
+	db.Create(session)
+	db.Create(code)
+
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	if err := s.Suspend(ada, "spam", nil, cy.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Suspend(bob, "spam", &future, cy.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	root := &domain.User{Username: "root", Email: "root@example.com", Password: "x", Role: domain.AdminRole}
+	db.Create(root)
+	if err := s.Suspend(root, "spam", nil, cy.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("suspending the last admin = %v, want ErrLastAdmin", err)
+	}
+	if IsSuspended(root, time.Now()) {
+		t.Error("refused suspension is set on the user")
+	}
+
+	access := func(user *domain.User, sessionID uint) Access {
+		t.Helper()
+		got, err := s.Access(user.ID, sessionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *got
+	}
+	checks := []struct {
+		name string
+		got  Access
+		want Access
+	}{
+		{"own session", access(ada, session.ID), Access{Found: true, Session: true, Suspended: true}},
+		{"no session", access(ada, 0), Access{Found: true, Suspended: true}},
+		{"a code", access(ada, code.ID), Access{Found: true, Suspended: true}},
+		{"someone else's session", access(cy, session.ID), Access{Found: true}},
+		{"suspended until later", access(bob, 0), Access{Found: true, Suspended: true}},
+		{"unknown user", access(&domain.User{ID: 999}, 0), Access{}},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s: Access = %+v, want %+v", c.name, c.got, c.want)
+		}
+	}
+
+	// bob's suspension runs out, ada's lasts until lifted
+	db.Model(bob).Update("suspended_until", past)
+	n, err := s.LiftExpired()
+	if err != nil || n != 1 {
+		t.Fatalf("LiftExpired = %d, %v, want 1", n, err)
+	}
+	stored := &domain.User{}
+	db.First(stored, bob.ID)
+	if stored.SuspendedAt != nil || stored.SuspendedUntil != nil || stored.SuspensionReason != "" || stored.SuspendedByID != nil {
+		t.Errorf("lifted suspension left %+v", stored)
+	}
+	if got := access(ada, session.ID); !got.Suspended {
+		t.Error("LiftExpired lifted a suspension without an end")
+	}
+
+	if err := s.Unsuspend(ada); err != nil {
+		t.Fatal(err)
+	}
+	if got := access(ada, session.ID); got.Suspended {
+		t.Error("Unsuspend left the user suspended")
+	}
+	db.Delete(session)
+	if got := access(ada, session.ID); got.Session {
+		t.Error("a deleted session is still live")
+	}
+	db.Delete(ada)
+	if got := access(ada, 0); got.Found {
+		t.Error("a deleted user is still found")
+	}
+}
@@ -1,11 +1,25 @@
 package services
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/helper"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+var (
+	ErrInvalidCode = errors.New(helper.ErrInvalidCode)
+	ErrExpiredCode = errors.New(helper.ErrExpiredCode)
 )
 
 type TokenService interface {
@@ -17,13 +31,31 @@ type TokenService interface {
 	) (*domain.Token, error)
 	GetTokenByID(id uint) (*domain.Token, error)
 	DeleteTokenByID(id uint) error
+	// RevokeUserTokens deletes the user's tokens of type ttype, sparing
+	// the one with keepID.
+	RevokeUserTokens(userID uint, ttype domain.TokenType, keepID uint) error
+	// IssueCode creates a one time code of type ttype for the user and
+	// invalidates the user's earlier codes of that type. Only a hash of the
+	// code is stored.
+	IssueCode(ttype domain.TokenType, userID uint, length uint, expires time.Time) (string, error)
+	// Consume spends the user's code of type ttype. A wrong code counts
+	// against the code's attempts, and the code is deleted once they run
+	// out. It returns ErrInvalidCode or ErrExpiredCode when the code can't
+	// be used.
+	Consume(ttype domain.TokenType, userID uint, code string) (*domain.Token, error)
+	// Check is Consume without spending the code, for requests that still
+	// have to be validated before it is used. A wrong code counts against
+	// its attempts all the same.
+	Check(ttype domain.TokenType, userID uint, code string) error
 }
 type tokenService struct {
-	db *gorm.DB
+	db          *gorm.DB
+	maxAttempts int
 }
 
-func NewTokenService(db *gorm.DB) TokenService {
-	return &tokenService{db: db}
+// NewTokenService allows maxAttempts guesses per one time code.
+func NewTokenService(db *gorm.DB, maxAttempts int) TokenService {
+	return &tokenService{db: db, maxAttempts: maxAttempts}
 }
 
 func (s *tokenService) CreateToken(
@@ -50,3 +82,99 @@ func (s *tokenService) GetTokenByID(id uint) (*domain.Token, error) {
 func (s *tokenService) DeleteTokenByID(id uint) error {
 	return s.db.Delete(&domain.Token{}, id).Error
 }
+
+func (s *tokenService) RevokeUserTokens(userID uint, ttype domain.TokenType, keepID uint) error {
+	return s.db.Where("user_id = ? AND type = ? AND id <> ?", userID, ttype, keepID).
+		Delete(&domain.Token{}).Error
+}
+
+func (s *tokenService) IssueCode(
+	ttype domain.TokenType,
+	userID uint,
+	length uint,
+	expires time.Time,
+) (string, error) {
+	code, err := util.GenerateCode(length)
+	if err != nil {
+		return "", err
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND type = ?", userID, ttype).Delete(&domain.Token{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&domain.Token{
+			Hash:      hashCode(ttype, userID, code),
+			Type:      ttype,
+			UserID:    userID,
+			ExpiresAt: expires,
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+func (s *tokenService) Consume(ttype domain.TokenType, userID uint, code string) (*domain.Token, error) {
+	return s.use(ttype, userID, code, true)
+}
+
+func (s *tokenService) Check(ttype domain.TokenType, userID uint, code string) error {
+	_, err := s.use(ttype, userID, code, false)
+	return err
+}
+
+// use checks code against the user's latest code of type ttype and deletes
+// it when spend is set.
+func (s *tokenService) use(ttype domain.TokenType, userID uint, code string, spend bool) (*domain.Token, error) {
+	token := &domain.Token{}
+	var result error
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND type = ?", userID, ttype).
+			Order("created_at desc").
+			First(token).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			result = ErrInvalidCode
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if time.Now().After(token.ExpiresAt) {
+			result = ErrExpiredCode
+			return tx.Delete(token).Error
+		}
+		want := []byte(token.Hash)
+		got := []byte(hashCode(ttype, userID, code))
+		if subtle.ConstantTimeCompare(want, got) != 1 {
+			result = ErrInvalidCode
+			token.Attempts++
+			if token.Attempts >= s.maxAttempts {
+				return tx.Delete(token).Error
+			}
+			return tx.Model(token).Update("attempts", token.Attempts).Error
+		}
+		if !spend {
+			return nil
+		}
+		return tx.Delete(token).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result != nil {
+		return nil, result
+	}
+	return token, nil
+}
+
+// hashCode binds a code to its owner and type, so a code can only be spent
+// for the purpose it was issued for.
+func hashCode(ttype domain.TokenType, userID uint, code string) string {
+	sum := sha256.Sum256([]byte(string(ttype) + ":" +
+		strconv.FormatUint(uint64(userID), 10) + ":" +
+		strings.ToUpper(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
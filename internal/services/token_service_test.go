@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func TestHashCode(t *testing.T) {
+	h := hashCode(domain.RESET_PASSWORD, 1, "AB12CD")
+	if got := hashCode(domain.RESET_PASSWORD, 1, " ab12cd "); got != h {
+		t.Error("the hash depends on case or surrounding spaces")
+	}
+	if hashCode(domain.VERIFY_EMAIL, 1, "AB12CD") == h {
+		t.Error("the hash doesn't depend on the code type")
+	}
+	if hashCode(domain.RESET_PASSWORD, 2, "AB12CD") == h {
+		t.Error("the hash doesn't depend on the user")
+	}
+	if hashCode(domain.RESET_PASSWORD, 1, "AB12CE") == h {
+		t.Error("the hash doesn't depend on the code")
+	}
+}
+
+func TestConsume(t *testing.T) {
+	db := dbtest.New(t)
+	s := NewTokenService(db, 3)
+	user := &domain.User{Username: "ada", Email: "ada@example.com", Password: "x", Role: domain.UserRole}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	issue := func(expires time.Time) string {
+		t.Helper()
+		code, err := s.IssueCode(domain.RESET_PASSWORD, user.ID, 6, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	consume := func(code string) error {
+		_, err := s.Consume(domain.RESET_PASSWORD, user.ID, code)
+		return err
+	}
+	hour := time.Now().Add(time.Hour)
+
+	code := issue(hour)
+	if err := s.Check(domain.RESET_PASSWORD, user.ID, code); err != nil {
+		t.Fatalf("Check = %v", err)
+	}
+	if _, err := s.Consume(domain.VERIFY_EMAIL, user.ID, code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("consuming as another type = %v, want ErrInvalidCode", err)
+	}
+	if err := consume(code); err != nil {
+		t.Fatalf("Consume after Check = %v", err)
+	}
+	if err := consume(code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("consuming twice = %v, want ErrInvalidCode", err)
+	}
+
+	old := issue(hour)
+	code = issue(hour)
+	if err := consume(old); !errors.Is(err, ErrInvalidCode) {
+		t.Error("an earlier code still works after a new one was issued")
+	}
+	if err := consume(code); err != nil {
+		t.Errorf("Consume = %v", err)
+	}
+
+	// wrong guesses, checked or not, use up the attempts
+	code = issue(hour)
+	if err := s.Check(domain.RESET_PASSWORD, user.ID, "WRONG1"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Check with a wrong code = %v", err)
+	}
+	consume("WRONG2")
+	consume("WRONG3")
+	if err := consume(code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Consume after running out of attempts = %v, want ErrInvalidCode", err)
+	}
+
+	code = issue(time.Now().Add(-time.Minute))
+	if err := consume(code); !errors.Is(err, ErrExpiredCode) {
+		t.Errorf("Consume of an expired code = %v, want ErrExpiredCode", err)
+	}
+	var left int64
+	db.Model(&domain.Token{}).Where("user_id = ?", user.ID).Count(&left)
+	if left != 0 {
+		t.Errorf("%d codes left behind", left)
+	}
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+// ExportRow is what an export holds about a user. It leaves out the
+// password hash and anything else only needed for signing in.
+type ExportRow struct {
+	ID              uint        `json:"id"`
+	Username        string      `json:"username"`
+	Email           string      `json:"email"`
+	Firstname       string      `json:"firstname"`
+	Lastname        string      `json:"lastname"`
+	Role            domain.Role `json:"role"`
+	AuthProvider    string      `json:"auth_provider"`
+	IsEmailVerified bool        `json:"is_email_verified"`
+	LastLoggedInAt  time.Time   `json:"last_logged_in_at"`
+	SuspendedAt     *time.Time  `json:"suspended_at"`
+	SuspendedUntil  *time.Time  `json:"suspended_until"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+	DeletedAt       *time.Time  `json:"deleted_at"`
+}
+
+var exportColumns = []string{
+	"id",
+	"username",
+	"email",
+	"firstname",
+	"lastname",
+	"role",
+	"auth_provider",
+	"is_email_verified",
+	"last_logged_in_at",
+	"suspended_at",
+	"suspended_until",
+	"created_at",
+	"updated_at",
+	"deleted_at",
+}
+
+type UserExporter interface {
+	// Export writes the users matching filter to w in format, reading them
+	// from the database as it goes rather than all at once.
+	Export(w io.Writer, format string, filter UserFilter) error
+}
+
+type userExporter struct {
+	db *gorm.DB
+}
+
+func NewUserExporter(db *gorm.DB) UserExporter {
+	return &userExporter{db: db}
+}
+
+func (s *userExporter) Export(w io.Writer, format string, filter UserFilter) error {
+	var write func(*ExportRow) error
+	var flush func() error
+	switch format {
+	case CSVFormat:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(exportColumns); err != nil {
+			return err
+		}
+		write = func(row *ExportRow) error { return cw.Write(row.record()) }
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case NDJSONFormat:
+		enc := json.NewEncoder(w)
+		write = func(row *ExportRow) error { return enc.Encode(row) }
+		flush = func() error { return nil }
+	default:
+		return ErrUnknownFormat
+	}
+
+	order, err := filter.Order()
+	if err != nil {
+		return err
+	}
+	query, err := filter.Apply(s.db.Model(&domain.User{}))
+	if err != nil {
+		return err
+	}
+	rows, err := query.Select(exportColumns).Order(order).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row := ExportRow{}
+		if err := s.db.ScanRows(rows, &row); err != nil {
+			return err
+		}
+		if err := write(&row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// record returns the row's fields in exportColumns order.
+func (r *ExportRow) record() []string {
+	formatTime := func(t *time.Time) string {
+		if t == nil || t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	return []string{
+		strconv.FormatUint(uint64(r.ID), 10),
+		csvText(r.Username),
+		csvText(r.Email),
+		csvText(r.Firstname),
+		csvText(r.Lastname),
+		string(r.Role),
+		csvText(r.AuthProvider),
+		strconv.FormatBool(r.IsEmailVerified),
+		formatTime(&r.LastLoggedInAt),
+		formatTime(r.SuspendedAt),
+		formatTime(r.SuspendedUntil),
+		formatTime(&r.CreatedAt),
+		formatTime(&r.UpdatedAt),
+		formatTime(r.DeletedAt),
+	}
+}
+
+// csvText keeps spreadsheets from running user supplied text as a formula
+// by quoting values that start like one.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func TestExportRowRecord(t *testing.T) {
+	created := time.Date(2024, 2, 1, 10, 0, 0, 0, time.FixedZone("WAT", 3600))
+	row := ExportRow{
+		ID:              7,
+		Username:        "@ada",
+		Email:           "ada@example.com",
+		Firstname:       "=HYPERLINK(\"http://evil.test\")",
+		Lastname:        "-1+1",
+		Role:            domain.AdminRole,
+		AuthProvider:    "+cmd",
+		IsEmailVerified: true,
+		CreatedAt:       created,
+	}
+	want := []string{
+		"7", "'@ada", "ada@example.com", "'=HYPERLINK(\"http://evil.test\")", "'-1+1", "admin", "'+cmd", "true",
+		"", "", "", "2024-02-01T09:00:00Z", "", "",
+	}
+	if got := row.record(); !slices.Equal(got, want) {
+		t.Errorf("record = %q, want %q", got, want)
+	}
+	for _, s := range []string{"\tx", "\rx"} {
+		if got := csvText(s); got != "'"+s {
+			t.Errorf("csvText(%q) = %q", s, got)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	db := dbtest.New(t)
+	for _, name := range []string{"=ada", "bob"} {
+		user := &domain.User{Username: name, Email: name + "@example.com", Password: "secret-hash", Role: domain.UserRole}
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	exporter := NewUserExporter(db)
+
+	var buf bytes.Buffer
+	if err := exporter.Export(&buf, NDJSONFormat, UserFilter{Sort: "username"}); err != nil {
+		t.Fatal(err)
+	}
+	var usernames []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var row map[string]interface{}
+		if err := dec.Decode(&row); err != nil {
+			t.Fatal(err)
+		}
+		if _, leaked := row["password"]; leaked {
+			t.Errorf("row %v has the password", row)
+		}
+		usernames = append(usernames, row["username"].(string))
+	}
+	// JSON is data to whatever reads it, it is left as it is
+	if want := []string{"=ada", "bob"}; !slices.Equal(usernames, want) {
+		t.Errorf("ndjson usernames = %v, want %v", usernames, want)
+	}
+
+	buf.Reset()
+	if err := exporter.Export(&buf, CSVFormat, UserFilter{Sort: "username"}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || !slices.Equal(records[0], exportColumns) {
+		t.Fatalf("csv = %q", records)
+	}
+	if records[1][1] != "'=ada" || records[2][1] != "bob" {
+		t.Errorf("csv usernames = %q, %q", records[1][1], records[2][1])
+	}
+
+	if err := exporter.Export(&buf, "xlsx", UserFilter{}); err != ErrUnknownFormat {
+		t.Errorf("Export(xlsx) = %v, want ErrUnknownFormat", err)
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+	"github.com/ostheperson/go-auth-service/internal/util"
+)
+
+const (
+	UserStateActive    = "active"
+	UserStateDeleted   = "deleted"
+	UserStateSuspended = "suspended"
+	UserStateAll       = "all"
+)
+
+// UserSortFields maps sort names accepted from clients to columns.
+var UserSortFields = map[string]string{
+	"id":                "id",
+	"username":          "username",
+	"email":             "email",
+	"role":              "role",
+	"created_at":        "created_at",
+	"last_logged_in_at": "last_logged_in_at",
+}
+
+var (
+	ErrUnknownSortField = errors.New("unknown sort field")
+	ErrUnknownUserState = errors.New("unknown user state")
+)
+
+// UserFilter selects users for admin listings and exports. Zero values
+// don't filter.
+type UserFilter struct {
+	Role            domain.Role
+	EmailVerified   *bool
+	CreatedAfter    *time.Time
+	CreatedBefore   *time.Time
+	LastLoginAfter  *time.Time
+	LastLoginBefore *time.Time
+	// State is one of the UserState constants, active when empty. Active
+	// users are the ones neither deleted nor suspended.
+	State string
+	// Query is matched case insensitively against username, email and
+	// names.
+	Query string
+	// Sort names a UserSortFields key, prefixed with "-" for descending.
+	Sort string
+}
+
+// Apply adds the filter's conditions to db, which should be a query on
+// users.
+func (f UserFilter) Apply(db *gorm.DB) (*gorm.DB, error) {
+	switch f.State {
+	case "", UserStateActive:
+		db = db.Scopes(notSuspended(time.Now()))
+	case UserStateDeleted:
+		db = db.Unscoped().Where("deleted_at IS NOT NULL")
+	case UserStateSuspended:
+		db = db.Scopes(suspended(time.Now()))
+	case UserStateAll:
+		db = db.Unscoped()
+	default:
+		return nil, ErrUnknownUserState
+	}
+	if f.Role != "" {
+		db = db.Where("role = ?", f.Role)
+	}
+	if f.EmailVerified != nil {
+		db = db.Where("is_email_verified = ?", *f.EmailVerified)
+	}
+	if f.CreatedAfter != nil {
+		db = db.Where("created_at >= ?", *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		db = db.Where("created_at < ?", *f.CreatedBefore)
+	}
+	if f.LastLoginAfter != nil {
+		db = db.Where("last_logged_in_at >= ?", *f.LastLoginAfter)
+	}
+	if f.LastLoginBefore != nil {
+		db = db.Where("last_logged_in_at < ?", *f.LastLoginBefore)
+	}
+	if q := strings.TrimSpace(f.Query); q != "" {
+		db = db.Where(domain.UserSearchExpression+" ILIKE ?", "%"+escapeLike(q)+"%")
+	}
+	return db, nil
+}
+
+// Order returns the ORDER BY clause for Sort, always ending in id so pages
+// are stable.
+func (f UserFilter) Order() (string, error) {
+	keyset, err := f.keyset()
+	if err != nil {
+		return "", err
+	}
+	return keyset.Order(), nil
+}
+
+// Seek orders db by Sort and restricts it to the rows after cursor, or
+// before it when cursor.Before is set. Rows before the cursor come back in
+// reverse order, closest first.
+func (f UserFilter) Seek(db *gorm.DB, cursor *util.Cursor) (*gorm.DB, error) {
+	keyset, err := f.keyset()
+	if err != nil {
+		return nil, err
+	}
+	return keyset.Seek(db, cursor)
+}
+
+// Cursor returns a cursor positioned at user for the filter's Sort.
+func (f UserFilter) Cursor(user *domain.User, before bool) *util.Cursor {
+	keyset, _ := f.keyset()
+	switch keyset.Column {
+	case "username":
+		return keyset.Cursor(user.Username, user.ID, before)
+	case "email":
+		return keyset.Cursor(user.Email, user.ID, before)
+	case "role":
+		return keyset.Cursor(string(user.Role), user.ID, before)
+	case "created_at":
+		return keyset.TimeCursor(user.CreatedAt, user.ID, before)
+	case "last_logged_in_at":
+		return keyset.TimeCursor(user.LastLoggedInAt, user.ID, before)
+	}
+	return keyset.Cursor("", user.ID, before)
+}
+
+func (f UserFilter) keyset() (util.Keyset, error) {
+	if f.Sort == "" {
+		return util.Keyset{Column: "id"}, nil
+	}
+	name, desc := strings.CutPrefix(f.Sort, "-")
+	column, ok := UserSortFields[name]
+	if !ok {
+		return util.Keyset{}, ErrUnknownSortField
+	}
+	return util.Keyset{
+		Sort:   f.Sort,
+		Column: column,
+		Desc:   desc,
+		Time:   column == "created_at" || column == "last_logged_in_at",
+	}, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func TestUserFilterOrder(t *testing.T) {
+	tests := []struct {
+		sort string
+		want string
+		err  error
+	}{
+		{"", "id asc", nil},
+		{"id", "id asc", nil},
+		{"-id", "id desc", nil},
+		{"created_at", "created_at asc, id asc", nil},
+		{"-last_logged_in_at", "last_logged_in_at desc, id desc", nil},
+		{"password", "", ErrUnknownSortField},
+		{"-password", "", ErrUnknownSortField},
+		{"email; drop table users", "", ErrUnknownSortField},
+		{"--email", "", ErrUnknownSortField},
+	}
+	for _, tt := range tests {
+		got, err := UserFilter{Sort: tt.sort}.Order()
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("Order(%q) = %q, %v, want %q, %v", tt.sort, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestUserFilterApply(t *testing.T) {
+	db := dbtest.New(t)
+	now := time.Now()
+	hourAgo := now.Add(-time.Hour)
+	lastWeek := now.AddDate(0, 0, -7)
+	users := []*domain.User{
+		{Username: "ada", Role: domain.AdminRole, IsEmailVerified: true, CreatedAt: lastWeek, LastLoggedInAt: hourAgo},
+		{Username: "bob", Role: domain.UserRole, CreatedAt: hourAgo},
+		{Username: "cy", Role: domain.UserRole, IsEmailVerified: true, CreatedAt: now, SuspendedAt: &hourAgo},
+		{Username: "dee", Role: domain.UserRole, CreatedAt: lastWeek},
+	}
+	for _, u := range users {
+		u.Email = u.Username + "@example.com"
+		u.Password = "x"
+		if err := db.Create(u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Delete(users[3])
+
+	verified, unverified := true, false
+	tests := []struct {
+		name   string
+		filter UserFilter
+		want   []string
+	}{
+		{"default", UserFilter{}, []string{"ada", "bob"}},
+		{"active", UserFilter{State: UserStateActive}, []string{"ada", "bob"}},
+		{"deleted", UserFilter{State: UserStateDeleted}, []string{"dee"}},
+		{"suspended", UserFilter{State: UserStateSuspended}, []string{"cy"}},
+		{"all", UserFilter{State: UserStateAll}, []string{"ada", "bob", "cy", "dee"}},
+		{"role", UserFilter{Role: domain.UserRole}, []string{"bob"}},
+		{"verified", UserFilter{EmailVerified: &verified}, []string{"ada"}},
+		{"unverified", UserFilter{EmailVerified: &unverified}, []string{"bob"}},
+		{"created after", UserFilter{CreatedAfter: &hourAgo}, []string{"bob"}},
+		{"suspended and created after", UserFilter{State: UserStateSuspended, CreatedAfter: &hourAgo}, []string{"cy"}},
+		{"created before", UserFilter{CreatedBefore: &hourAgo}, []string{"ada"}},
+		{"logged in after", UserFilter{LastLoginAfter: &lastWeek}, []string{"ada"}},
+		{"deleted and created before", UserFilter{State: UserStateDeleted, CreatedBefore: &hourAgo}, []string{"dee"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := tt.filter.Apply(db.Model(&domain.User{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			if err := q.Order("username").Pluck("username", &got).Error; err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := (UserFilter{State: "banned"}).Apply(db); !errors.Is(err, ErrUnknownUserState) {
+		t.Errorf("unknown state = %v, want ErrUnknownUserState", err)
+	}
+}
+
+func TestUserFilterQueryEscapesLike(t *testing.T) {
+	db := dbtest.New(t)
+	q, err := UserFilter{Query: ` 50%_off\ `}.Apply(db.Model(&domain.User{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt := q.Session(&gorm.Session{DryRun: true}).Find(&[]domain.User{}).Statement
+	if !strings.Contains(stmt.SQL.String(), domain.UserSearchExpression+" ILIKE ?") {
+		t.Errorf("query %s doesn't search the indexed expression", stmt.SQL.String())
+	}
+	if want := `%50\%\_off\\%`; !slices.Contains(stmt.Vars, interface{}(want)) {
+		t.Errorf("vars = %v, want the pattern %s", stmt.Vars, want)
+	}
+}
@@ -0,0 +1,386 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+const (
+	CSVFormat    = "csv"
+	NDJSONFormat = "ndjson"
+)
+
+// importBatchSize is how many rows are written per statement.
+const importBatchSize = 500
+
+var ErrUnknownFormat = errors.New("unknown format, use csv or ndjson")
+
+// ImportRow is one user in an import. CSV files name these fields in their
+// header row, in any order.
+type ImportRow struct {
+	Email         string      `json:"email"`
+	Username      string      `json:"username"`
+	Firstname     string      `json:"firstname"`
+	Lastname      string      `json:"lastname"`
+	Role          domain.Role `json:"role"`
+	PasswordHash  string      `json:"password_hash"`
+	EmailVerified bool        `json:"email_verified"`
+}
+
+type ImportError struct {
+	// Row counts data rows from 1, a CSV header is not a row.
+	Row   int    `json:"row"`
+	Email string `json:"email,omitempty"`
+	Error string `json:"error"`
+}
+
+type ImportReport struct {
+	DryRun  bool          `json:"dry_run"`
+	Total   int           `json:"total"`
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Failed  int           `json:"failed"`
+	Errors  []ImportError `json:"errors"`
+}
+
+// UserImporter loads users from another system. Rows are matched to
+// existing users by email, which only take the names and role from them.
+// Password hashes of new users are stored as they are, so they must be in a
+// format and cost PasswordHasher accepts.
+type UserImporter interface {
+	// Import reads rows in format from r. Invalid rows are reported and
+	// skipped. With dryRun nothing is written, the report says what would
+	// have been. The error is only set when reading r fails.
+	Import(r io.Reader, format string, dryRun bool) (*ImportReport, error)
+}
+
+type userImporter struct {
+	db     *gorm.DB
+	hasher PasswordHasher
+	admins AdminGuard
+}
+
+func NewUserImporter(db *gorm.DB, hasher PasswordHasher) UserImporter {
+	return &userImporter{db: db, hasher: hasher, admins: NewAdminGuard()}
+}
+
+type importRow struct {
+	ImportRow
+	n int
+}
+
+func (s *userImporter) Import(r io.Reader, format string, dryRun bool) (*ImportReport, error) {
+	next, err := importReader(r, format)
+	if err != nil {
+		return nil, err
+	}
+	report := &ImportReport{DryRun: dryRun, Errors: []ImportError{}}
+	seenEmails := map[string]bool{}
+	seenUsernames := map[string]bool{}
+	batch := make([]importRow, 0, importBatchSize)
+	for {
+		row, err := next()
+		if err == io.EOF {
+			break
+		}
+		report.Total++
+		if err != nil {
+			var parseErr *rowError
+			if !errors.As(err, &parseErr) {
+				return report, err
+			}
+			report.fail(report.Total, "", parseErr.Error())
+			continue
+		}
+		if err := validateImportRow(row, s.hasher); err != nil {
+			report.fail(report.Total, row.Email, err.Error())
+			continue
+		}
+		if seenEmails[row.Email] || seenUsernames[row.Username] {
+			report.fail(report.Total, row.Email, "duplicate of an earlier row")
+			continue
+		}
+		seenEmails[row.Email] = true
+		seenUsernames[row.Username] = true
+		batch = append(batch, importRow{ImportRow: *row, n: report.Total})
+		if len(batch) == importBatchSize {
+			s.importBatch(batch, dryRun, report)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		s.importBatch(batch, dryRun, report)
+	}
+	return report, nil
+}
+
+// importBatch drops rows whose username belongs to another user or whose
+// email belongs to a deleted one, then writes the rest.
+func (s *userImporter) importBatch(batch []importRow, dryRun bool, report *ImportReport) {
+	emails := make([]string, len(batch))
+	usernames := make([]string, len(batch))
+	for i, row := range batch {
+		emails[i] = row.Email
+		usernames[i] = row.Username
+	}
+	var existing []domain.User
+	err := s.db.Unscoped().
+		Select("id", "email", "username", "role", "deleted_at").
+		Where("email IN ? OR username IN ?", emails, usernames).
+		Find(&existing).Error
+	if err != nil {
+		for _, row := range batch {
+			report.fail(row.n, row.Email, err.Error())
+		}
+		return
+	}
+	byEmail := map[string]*domain.User{}
+	usernameOwner := map[string]string{}
+	for i, user := range existing {
+		byEmail[user.Email] = &existing[i]
+		usernameOwner[user.Username] = user.Email
+	}
+
+	writes := make([]importWrite, 0, len(batch))
+	for _, row := range batch {
+		if owner, taken := usernameOwner[row.Username]; taken && owner != row.Email {
+			report.fail(row.n, row.Email, "username belongs to another user")
+			continue
+		}
+		user := byEmail[row.Email]
+		if user != nil && user.DeletedAt.Valid {
+			report.fail(row.n, row.Email, "email belongs to a deleted user")
+			continue
+		}
+		writes = append(writes, importWrite{row: row, existing: user})
+	}
+	if len(writes) == 0 {
+		return
+	}
+	failed := make([]bool, len(writes))
+	if !dryRun {
+		if err := s.write(writes); err != nil {
+			// find the rows at fault one at a time
+			for i := range writes {
+				if err := s.write(writes[i : i+1]); err != nil {
+					report.fail(writes[i].row.n, writes[i].row.Email, err.Error())
+					failed[i] = true
+				}
+			}
+		}
+	}
+	for i, w := range writes {
+		switch {
+		case failed[i]:
+		case w.existing != nil:
+			report.Updated++
+		default:
+			report.Created++
+		}
+	}
+}
+
+// importWrite is a row to create a user from, or to update existing with.
+type importWrite struct {
+	row      importRow
+	existing *domain.User
+}
+
+// write applies writes in one transaction. New users get everything in
+// their row. Existing users only get their names and role from it: they
+// keep their username, password and verification, which the import can't
+// vouch for. Taking a role away ends the user's sessions, and is refused
+// when it would leave no admin.
+func (s *userImporter) write(writes []importWrite) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var created []domain.User
+		var demoted []uint
+		for _, w := range writes {
+			if w.existing == nil {
+				created = append(created, importedUser(w.row.ImportRow, now))
+			} else if w.existing.Role == domain.AdminRole && w.row.Role != domain.AdminRole {
+				demoted = append(demoted, w.existing.ID)
+			}
+		}
+		if err := s.admins.Check(tx, demoted...); err != nil {
+			return err
+		}
+		if len(created) > 0 {
+			if err := tx.Create(&created).Error; err != nil {
+				return err
+			}
+		}
+		for _, w := range writes {
+			if w.existing == nil {
+				continue
+			}
+			err := tx.Model(&domain.User{}).Where("id = ?", w.existing.ID).Updates(map[string]interface{}{
+				"firstname":  w.row.Firstname,
+				"lastname":   w.row.Lastname,
+				"role":       w.row.Role,
+				"updated_at": now,
+			}).Error
+			if err != nil {
+				return err
+			}
+			if w.existing.Role == w.row.Role {
+				continue
+			}
+			err = tx.Where("user_id = ? AND type = ?", w.existing.ID, domain.REFRESH).Delete(&domain.Token{}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func importedUser(row ImportRow, now time.Time) domain.User {
+	user := domain.User{
+		Email:             row.Email,
+		Username:          row.Username,
+		Firstname:         row.Firstname,
+		Lastname:          row.Lastname,
+		Role:              row.Role,
+		Password:          row.PasswordHash,
+		PasswordChangedAt: &now,
+		IsEmailVerified:   row.EmailVerified,
+	}
+	if row.EmailVerified {
+		user.VerifiedAt = now
+	}
+	return user
+}
+
+func (r *ImportReport) fail(row int, email, reason string) {
+	r.Failed++
+	r.Errors = append(r.Errors, ImportError{Row: row, Email: email, Error: reason})
+}
+
+func validateImportRow(row *ImportRow, hasher PasswordHasher) error {
+	row.Email = strings.TrimSpace(row.Email)
+	row.Username = strings.TrimSpace(row.Username)
+	if addr, err := mail.ParseAddress(row.Email); err != nil || addr.Address != row.Email {
+		return errors.New("invalid email")
+	}
+	if row.Username == "" {
+		return errors.New("missing username")
+	}
+	if row.Role == "" {
+		row.Role = domain.UserRole
+	}
+	if _, known := domain.Roles[row.Role]; !known {
+		return fmt.Errorf("unknown role %s", row.Role)
+	}
+	if !hasher.IsSupportedHash(row.PasswordHash) {
+		return ErrUnknownHashFormat
+	}
+	return nil
+}
+
+// rowError is a row that could not be decoded. Reading carries on after it.
+type rowError struct {
+	err error
+}
+
+func (e *rowError) Error() string {
+	return e.err.Error()
+}
+
+// importReader returns a function yielding the rows of r one at a time,
+// and io.EOF after the last.
+func importReader(r io.Reader, format string) (func() (*ImportRow, error), error) {
+	switch format {
+	case CSVFormat:
+		return csvImportReader(r)
+	case NDJSONFormat:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		return func() (*ImportRow, error) {
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" {
+					continue
+				}
+				row := &ImportRow{}
+				if err := json.Unmarshal([]byte(line), row); err != nil {
+					return nil, &rowError{err}
+				}
+				return row, nil
+			}
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+func csvImportReader(r io.Reader) (func() (*ImportRow, error), error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"email", "username", "password_hash"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %s column", required)
+		}
+	}
+	return func() (*ImportRow, error) {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, &rowError{err}
+			}
+			return nil, err
+		}
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return record[i]
+		}
+		row := &ImportRow{
+			Email:        field("email"),
+			Username:     field("username"),
+			Firstname:    field("firstname"),
+			Lastname:     field("lastname"),
+			Role:         domain.Role(field("role")),
+			PasswordHash: field("password_hash"),
+		}
+		if v := field("email_verified"); v != "" {
+			verified, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, &rowError{errors.New("invalid email_verified")}
+			}
+			row.EmailVerified = verified
+		}
+		return row, nil
+	}, nil
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func TestImportReaderCSV(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("legacy"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "Username,email,password_hash,role,email_verified\n" +
+		"ada,ada@example.com," + string(hash) + ",admin,true\n" +
+		"bob,not an email," + string(hash) + ",,\n" +
+		"eve,eve@example.com,plaintext,,\n" +
+		"sam,sam@example.com," + string(hash) + ",,maybe\n"
+
+	next, err := importReader(strings.NewReader(input), CSVFormat)
+	if err != nil {
+		t.Fatalf("importReader returned an error: %v", err)
+	}
+	var rows []*ImportRow
+	var errs []error
+	for {
+		row, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = validateImportRow(row, NewPasswordHasher(testHasherConfig()))
+		}
+		rows = append(rows, row)
+		errs = append(errs, err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want 4", len(rows))
+	}
+	if errs[0] != nil || rows[0].Role != domain.AdminRole || !rows[0].EmailVerified {
+		t.Errorf("row 1 = %+v, %v", rows[0], errs[0])
+	}
+	for i, want := range []string{"invalid email", ErrUnknownHashFormat.Error(), "invalid email_verified"} {
+		if errs[i+1] == nil || errs[i+1].Error() != want {
+			t.Errorf("row %d error = %v, want %s", i+2, errs[i+1], want)
+		}
+	}
+}
+
+func TestImportReaderCSVMissingColumn(t *testing.T) {
+	if _, err := importReader(strings.NewReader("email,username\n"), CSVFormat); err == nil {
+		t.Error("importReader accepted a header without password_hash")
+	}
+}
+
+func TestImportKeepsLastAdmin(t *testing.T) {
+	db := dbtest.New(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("legacy"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ada", "bob"} {
+		admin := &domain.User{Username: name, Email: name + "@example.com", Password: "x", Role: domain.AdminRole}
+		if err := db.Create(admin).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	importer := NewUserImporter(db, NewPasswordHasher(testHasherConfig()))
+	input := "email,username,password_hash,role\n" +
+		"ada@example.com,ada," + string(hash) + ",user\n" +
+		"bob@example.com,bob," + string(hash) + ",user\n"
+	report, err := importer.Import(strings.NewReader(input), CSVFormat, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Updated != 1 || report.Failed != 1 || report.Errors[0].Error != ErrLastAdmin.Error() {
+		t.Errorf("report = %+v, want one demotion refused", report)
+	}
+	var admins int64
+	db.Model(&domain.User{}).Where("role = ?", domain.AdminRole).Count(&admins)
+	if admins != 1 {
+		t.Errorf("%d admins left, want 1", admins)
+	}
+}
+
+func TestImport(t *testing.T) {
+	db := dbtest.New(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("legacy"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(user *domain.User) *domain.User {
+		user.Email = user.Username + "@example.com"
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	create(&domain.User{Username: "root", Password: "x", Role: domain.AdminRole})
+	ada := create(&domain.User{Username: "ada", Password: "ada-hash", Role: domain.AdminRole, Firstname: "Ada"})
+	bob := create(&domain.User{Username: "bob", Password: "bob-hash", Role: domain.UserRole})
+	gone := create(&domain.User{Username: "gone", Password: "x", Role: domain.UserRole})
+	db.Delete(gone)
+	adaSession := &domain.Token{UserID: ada.ID, Type: domain.REFRESH, Hash: "ada"}
+	bobSession := &domain.Token{UserID: bob.ID, Type: domain.REFRESH, Hash: "bob"}
+	db.Create(adaSession)
+	db.Create(bobSession)
+
+	importer := NewUserImporter(db, NewPasswordHasher(testHasherConfig()))
+	input := "email,username,firstname,password_hash,role,email_verified\n" +
+		// existing: names and role only
+		"ada@example.com,ada-renamed,Augusta," + string(hash) + ",user,true\n" +
+		"bob@example.com,bob,Robert," + string(hash) + ",user,true\n" +
+		// new
+		"cy@example.com,cy,Cy," + string(hash) + ",,true\n" +
+		// refused
+		"gone@example.com,gone,," + string(hash) + ",,\n" +
+		"dee@example.com,root,," + string(hash) + ",,\n"
+
+	report, err := importer.Import(strings.NewReader(input), CSVFormat, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 1 || report.Updated != 2 || report.Failed != 2 {
+		t.Errorf("dry run report = %+v", report)
+	}
+	var count int64
+	db.Unscoped().Model(&domain.User{}).Count(&count)
+	if count != 4 {
+		t.Fatalf("dry run left %d users, want 4", count)
+	}
+
+	report, err = importer.Import(strings.NewReader(input), CSVFormat, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 1 || report.Updated != 2 || report.Failed != 2 {
+		t.Errorf("report = %+v", report)
+	}
+	wantErrors := map[int]string{4: "email belongs to a deleted user", 5: "username belongs to another user"}
+	for _, e := range report.Errors {
+		if wantErrors[e.Row] != e.Error {
+			t.Errorf("row %d error %q, want %q", e.Row, e.Error, wantErrors[e.Row])
+		}
+	}
+
+	stored := &domain.User{}
+	db.First(stored, ada.ID)
+	if stored.Username != "ada" || stored.Password != "ada-hash" || stored.IsEmailVerified {
+		t.Errorf("import overwrote ada's credentials: %+v", stored)
+	}
+	if stored.Firstname != "Augusta" || stored.Role != domain.UserRole {
+		t.Errorf("import didn't update ada's profile and role: %+v", stored)
+	}
+	if db.First(&domain.Token{}, adaSession.ID).Error == nil {
+		t.Error("ada's session survived losing the admin role")
+	}
+	if db.First(&domain.Token{}, bobSession.ID).Error != nil {
+		t.Error("bob's session ended though his role didn't change")
+	}
+	cy := &domain.User{}
+	if err := db.Where("email = ?", "cy@example.com").First(cy).Error; err != nil {
+		t.Fatal(err)
+	}
+	if cy.Password != string(hash) || cy.Role != domain.UserRole || !cy.IsEmailVerified || cy.PasswordChangedAt == nil {
+		t.Errorf("created %+v", cy)
+	}
+	stored = &domain.User{}
+	db.Unscoped().First(stored, gone.ID)
+	if !stored.DeletedAt.Valid || stored.Password != "x" {
+		t.Errorf("import touched the deleted user: %+v", stored)
+	}
+}
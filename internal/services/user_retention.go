@@ -0,0 +1,182 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+const (
+	PurgeDeletion     = "purge"
+	AnonymizeDeletion = "anonymize"
+)
+
+// purgeBatchSize bounds how many users one purge transaction removes.
+const purgeBatchSize = 100
+
+// CheckDeletionMode returns an error unless mode is PurgeDeletion or
+// AnonymizeDeletion.
+func CheckDeletionMode(mode string) error {
+	if mode != PurgeDeletion && mode != AnonymizeDeletion {
+		return fmt.Errorf("unknown account deletion mode %q, use %s or %s", mode, PurgeDeletion, AnonymizeDeletion)
+	}
+	return nil
+}
+
+type UserRetentionService interface {
+	// Purge removes the users for good, with their tokens, password
+	// history and sign-in history, and scrubs them from the audit log.
+	Purge(userIDs []uint) error
+	// PurgeDeleted purges the users soft-deleted before before, except
+	// anonymized ones, and returns how many there were.
+	PurgeDeleted(before time.Time) (int64, error)
+	// Anonymize strips the users of anything identifying, removes their
+	// tokens, password history and sign-in history and scrubs them from
+	// the audit log. The rows are kept, soft-deleted and marked
+	// anonymized, for whatever still refers to them.
+	Anonymize(userIDs []uint) error
+	// DeleteScheduled purges, or anonymizes with AnonymizeDeletion, the
+	// users whose scheduled deletion is due at now and returns how many
+	// there were.
+	DeleteScheduled(now time.Time, mode string) (int64, error)
+	// ReleaseIdentifiers replaces a deleted user's email and username with
+	// placeholders so they can be used by another account.
+	ReleaseIdentifiers(user *domain.User) error
+}
+
+type userRetentionService struct {
+	db *gorm.DB
+}
+
+func NewUserRetentionService(db *gorm.DB) UserRetentionService {
+	return &userRetentionService{db: db}
+}
+
+func (s *userRetentionService) Purge(userIDs []uint) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteDependents(tx, userIDs); err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id IN ?", userIDs).Delete(&domain.User{}).Error
+	})
+}
+
+func (s *userRetentionService) PurgeDeleted(before time.Time) (int64, error) {
+	var total int64
+	for {
+		var ids []uint
+		err := s.db.Unscoped().Model(&domain.User{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ? AND anonymized_at IS NULL", before).
+			Limit(purgeBatchSize).
+			Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return total, err
+		}
+		if err := s.Purge(ids); err != nil {
+			return total, err
+		}
+		total += int64(len(ids))
+	}
+}
+
+func (s *userRetentionService) Anonymize(userIDs []uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteDependents(tx, userIDs); err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, id := range userIDs {
+			email, username := ReleasedIdentifiers(id)
+			err := tx.Unscoped().Model(&domain.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+				"email":                 email,
+				"username":              username,
+				"password":              "",
+				"phone":                 nil,
+				"firstname":             "",
+				"lastname":              "",
+				"avatar_url":            "",
+				"deletion_scheduled_at": nil,
+				"anonymized_at":         now,
+				"deleted_at":            now,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *userRetentionService) DeleteScheduled(now time.Time, mode string) (int64, error) {
+	if err := CheckDeletionMode(mode); err != nil {
+		return 0, err
+	}
+	remove := s.Purge
+	if mode == AnonymizeDeletion {
+		remove = s.Anonymize
+	}
+	var total int64
+	for {
+		var ids []uint
+		err := s.db.Model(&domain.User{}).
+			Where("deletion_scheduled_at <= ?", now).
+			Limit(purgeBatchSize).
+			Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return total, err
+		}
+		if err := remove(ids); err != nil {
+			return total, err
+		}
+		total += int64(len(ids))
+	}
+}
+
+func (s *userRetentionService) ReleaseIdentifiers(user *domain.User) error {
+	user.Email, user.Username = ReleasedIdentifiers(user.ID)
+	user.Phone = nil
+	return s.db.Unscoped().Model(user).Updates(map[string]interface{}{
+		"email":    user.Email,
+		"username": user.Username,
+		"phone":    nil,
+	}).Error
+}
+
+// deleteDependents removes the rows that belong to the users, and the
+// details and IPs the audit log holds about them.
+func deleteDependents(tx *gorm.DB, userIDs []uint) error {
+	dependents := []interface{}{
+		&domain.Token{},
+		&domain.PasswordHistory{},
+		&domain.LoginAttempt{},
+	}
+	for _, model := range dependents {
+		if err := tx.Unscoped().Where("user_id IN ?", userIDs).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	targetIDs := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		targetIDs[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	err := tx.Model(&domain.AuditLog{}).
+		Where("target_type = ? AND target_id IN ?", "user", targetIDs).
+		Update("details", "").Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&domain.AuditLog{}).Where("actor_id IN ?", userIDs).Update("ip", "").Error
+}
+
+// ReleasedIdentifiers returns the placeholder email and username given to
+// the user with id when its own are released.
+func ReleasedIdentifiers(id uint) (string, string) {
+	return fmt.Sprintf("deleted-%d@invalid", id), fmt.Sprintf("deleted-%d", id)
+}
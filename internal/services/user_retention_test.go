@@ -0,0 +1,175 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+// retentionUser creates a user with a session, a password history entry
+// and a sign-in attempt.
+func retentionUser(t *testing.T, db *gorm.DB, name string) *domain.User {
+	t.Helper()
+	user := &domain.User{Username: name, Email: name + "@example.com", Password: "x"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	dependents := []interface{}{
+		&domain.Token{UserID: user.ID, Type: domain.REFRESH, Hash: name},
+		&domain.PasswordHistory{UserID: user.ID, Hash: "x"},
+		&domain.LoginAttempt{UserID: &user.ID, Outcome: domain.LoginSucceeded},
+	}
+	for _, row := range dependents {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+// dependents counts the rows left behind for the user with id.
+func dependents(db *gorm.DB, id uint) int64 {
+	var total int64
+	for _, model := range []interface{}{&domain.Token{}, &domain.PasswordHistory{}, &domain.LoginAttempt{}} {
+		var n int64
+		db.Unscoped().Model(model).Where("user_id = ?", id).Count(&n)
+		total += n
+	}
+	return total
+}
+
+func TestPurge(t *testing.T) {
+	db := dbtest.New(t)
+	s := NewUserRetentionService(db)
+	ada := retentionUser(t, db, "ada")
+	bob := retentionUser(t, db, "bob")
+
+	if err := s.Purge(nil); err != nil {
+		t.Errorf("Purge(nil) = %v", err)
+	}
+	if err := s.Purge([]uint{ada.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if db.Unscoped().First(&domain.User{}, ada.ID).Error == nil || dependents(db, ada.ID) != 0 {
+		t.Error("Purge left ada or her rows behind")
+	}
+	if db.First(&domain.User{}, bob.ID).Error != nil || dependents(db, bob.ID) != 3 {
+		t.Error("Purge touched bob")
+	}
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	db := dbtest.New(t)
+	s := NewUserRetentionService(db)
+	now := time.Now()
+	var old []*domain.User
+	// more than a batch
+	for i := 0; i < purgeBatchSize+5; i++ {
+		name := fmt.Sprintf("old%d", i)
+		user := &domain.User{Username: name, Email: name + "@example.com", Password: "x"}
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+		old = append(old, user)
+	}
+	db.Model(&domain.User{}).Where("id IN ?", ids(old)).Update("deleted_at", now.AddDate(0, 0, -40))
+	recent := retentionUser(t, db, "recent")
+	db.Model(recent).Update("deleted_at", now.AddDate(0, 0, -10))
+	active := retentionUser(t, db, "active")
+
+	n, err := s.PurgeDeleted(now.AddDate(0, 0, -30))
+	if err != nil || n != int64(len(old)) {
+		t.Fatalf("PurgeDeleted = %d, %v, want %d", n, err, len(old))
+	}
+	var left int64
+	db.Unscoped().Model(&domain.User{}).Count(&left)
+	if left != 2 {
+		t.Errorf("%d users left, want the recently deleted and the active one", left)
+	}
+	if dependents(db, recent.ID) != 3 || dependents(db, active.ID) != 3 {
+		t.Error("PurgeDeleted removed rows of users it kept")
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	db := dbtest.New(t)
+	s := NewUserRetentionService(db)
+	ada := retentionUser(t, db, "ada")
+	bob := retentionUser(t, db, "bob")
+	logs := []*domain.AuditLog{
+		{ActorID: &ada.ID, Action: "user.update", TargetType: "user", TargetID: "0", Details: "{}", IP: "10.0.0.1"},
+		{ActorID: &bob.ID, Action: "invite.create", TargetType: "user", TargetID: fmt.Sprint(ada.ID), Details: `{"email":"ada@example.com"}`, IP: "10.0.0.2"},
+		{ActorID: &bob.ID, Action: "invite.create", TargetType: "user", TargetID: fmt.Sprint(bob.ID), Details: `{"email":"bob@example.com"}`, IP: "10.0.0.2"},
+	}
+	for _, log := range logs {
+		if err := db.Create(log).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Anonymize([]uint{ada.ID}); err != nil {
+		t.Fatal(err)
+	}
+	stored := &domain.User{}
+	db.Unscoped().First(stored, ada.ID)
+	if stored.AnonymizedAt == nil || !stored.DeletedAt.Valid || stored.Email == ada.Email || dependents(db, ada.ID) != 0 {
+		t.Errorf("anonymized user is %+v", stored)
+	}
+	var scrubbed []domain.AuditLog
+	db.Order("id").Find(&scrubbed)
+	if scrubbed[0].IP != "" || scrubbed[1].Details != "" {
+		t.Errorf("audit log still holds ada's details: %+v", scrubbed[:2])
+	}
+	if scrubbed[1].IP == "" || scrubbed[2].Details == "" {
+		t.Errorf("audit log lost bob's details: %+v", scrubbed[1:])
+	}
+
+	if n, err := s.PurgeDeleted(time.Now().Add(time.Hour)); err != nil || n != 0 {
+		t.Errorf("PurgeDeleted = %d, %v, want anonymized users kept", n, err)
+	}
+	if db.Unscoped().First(&domain.User{}, ada.ID).Error != nil {
+		t.Error("PurgeDeleted removed an anonymized user")
+	}
+}
+
+func TestCheckDeletionMode(t *testing.T) {
+	for _, mode := range []string{PurgeDeletion, AnonymizeDeletion} {
+		if err := CheckDeletionMode(mode); err != nil {
+			t.Errorf("CheckDeletionMode(%q) = %v", mode, err)
+		}
+	}
+	if CheckDeletionMode("anonymise") == nil {
+		t.Error("CheckDeletionMode accepts a misspelt mode")
+	}
+	if _, err := NewUserRetentionService(dbtest.New(t)).DeleteScheduled(time.Now(), ""); err == nil {
+		t.Error("DeleteScheduled runs without a mode")
+	}
+}
+
+func TestReleaseIdentifiers(t *testing.T) {
+	db := dbtest.New(t)
+	user := retentionUser(t, db, "ada")
+	db.Delete(user)
+	if err := NewUserRetentionService(db).ReleaseIdentifiers(user); err != nil {
+		t.Fatal(err)
+	}
+	stored := &domain.User{}
+	db.Unscoped().First(stored, user.ID)
+	email, username := ReleasedIdentifiers(user.ID)
+	if stored.Email != email || stored.Username != username || !stored.DeletedAt.Valid {
+		t.Errorf("released user is %+v", stored)
+	}
+}
+
+func ids(users []*domain.User) []uint {
+	ids := make([]uint, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids
+}
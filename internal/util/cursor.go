@@ -0,0 +1,157 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a position in a keyset paginated listing: the row with sort
+// value Value and id ID. Before asks for the rows preceding it instead of
+// the ones following it. Sort records the ordering the cursor was made for,
+// so it can't be replayed against another one.
+type Cursor struct {
+	Sort   string `json:"s"`
+	Value  string `json:"v"`
+	ID     uint   `json:"id"`
+	Before bool   `json:"b,omitempty"`
+}
+
+// Encode returns the cursor as an opaque string for clients.
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor := &Cursor{}
+	if err := json.Unmarshal(b, cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// GetCursorParam returns the cursor in the query string, nil when there is
+// none and the listing should fall back to page based pagination.
+func GetCursorParam(c *gin.Context) (*Cursor, error) {
+	s := c.Query("cursor")
+	if s == "" {
+		return nil, nil
+	}
+	return DecodeCursor(s)
+}
+
+// Keyset is an ordering of a listing by Column and then id, both ascending
+// or both descending, that can be paginated with cursors. Sort names it in
+// the cursors it makes. Time is set when Column holds timestamps, which
+// cursors carry in RFC 3339 format.
+type Keyset struct {
+	Sort   string
+	Column string
+	Desc   bool
+	Time   bool
+}
+
+// Order returns the ORDER BY clause of the keyset.
+func (k Keyset) Order() string {
+	return keysetOrder(k.Column, k.Desc)
+}
+
+// Seek orders db by the keyset and restricts it to the rows after cursor, or
+// before it when cursor.Before is set. Rows before the cursor come back in
+// reverse order, closest first.
+func (k Keyset) Seek(db *gorm.DB, cursor *Cursor) (*gorm.DB, error) {
+	if cursor.Sort != k.Sort {
+		return nil, ErrInvalidCursor
+	}
+	desc := k.Desc != cursor.Before
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	if k.Column == "id" {
+		return db.Where("id "+op+" ?", cursor.ID).Order(keysetOrder(k.Column, desc)), nil
+	}
+	var value interface{} = cursor.Value
+	if k.Time {
+		t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		value = t
+	}
+	db = db.Where("("+k.Column+", id) "+op+" (?, ?)", value, cursor.ID)
+	return db.Order(keysetOrder(k.Column, desc)), nil
+}
+
+// Cursor returns a cursor at the row with id and sort value value.
+func (k Keyset) Cursor(value string, id uint, before bool) *Cursor {
+	return &Cursor{Sort: k.Sort, Value: value, ID: id, Before: before}
+}
+
+// TimeCursor is Cursor for keysets on a timestamp column.
+func (k Keyset) TimeCursor(value time.Time, id uint, before bool) *Cursor {
+	return k.Cursor(value.Format(time.RFC3339Nano), id, before)
+}
+
+func keysetOrder(column string, desc bool) string {
+	direction := " asc"
+	if desc {
+		direction = " desc"
+	}
+	if column == "id" {
+		return column + direction
+	}
+	return column + direction + ", id" + direction
+}
+
+// KeysetPage turns rows, read with a limit of limit+1 from a query
+// restricted by Seek, or from the start of the listing when cursor is nil,
+// into a page in listing order. It returns the cursors of the pages next
+// to it, empty when there is none. cursorAt makes a cursor at a row.
+func KeysetPage[T any](
+	rows []T,
+	limit int,
+	cursor *Cursor,
+	cursorAt func(row *T, before bool) *Cursor,
+) (page []T, next, prev string) {
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
+	if cursor != nil && cursor.Before {
+		slices.Reverse(rows)
+	}
+	if len(rows) == 0 {
+		return rows, "", ""
+	}
+	first, last := &rows[0], &rows[len(rows)-1]
+	switch {
+	case cursor == nil:
+		if hasMore {
+			next = cursorAt(last, false).Encode()
+		}
+	case cursor.Before:
+		next = cursorAt(last, false).Encode()
+		if hasMore {
+			prev = cursorAt(first, true).Encode()
+		}
+	default:
+		if hasMore {
+			next = cursorAt(last, false).Encode()
+		}
+		prev = cursorAt(first, true).Encode()
+	}
+	return rows, next, prev
+}
@@ -0,0 +1,140 @@
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ostheperson/go-auth-service/internal/dbtest"
+	"github.com/ostheperson/go-auth-service/internal/domain"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := Cursor{Sort: "-created_at", Value: "2024-02-01T10:00:00Z", ID: 42, Before: true}
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("DecodeCursor returned an error: %v", err)
+	}
+	if *decoded != cursor {
+		t.Errorf("got %+v, want %+v", *decoded, cursor)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	for _, s := range []string{"not base64!", "bm90IGpzb24"} {
+		if _, err := DecodeCursor(s); err != ErrInvalidCursor {
+			t.Errorf("DecodeCursor(%q) = %v, want ErrInvalidCursor", s, err)
+		}
+	}
+}
+
+func TestKeysetPages(t *testing.T) {
+	db := dbtest.New(t)
+	base := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)
+	// ids 1-7, where 2-4 and 5-6 share a timestamp
+	offsets := []int{0, 1, 1, 1, 2, 2, 3}
+	for _, minutes := range offsets {
+		entry := domain.AuditLog{Action: "test", CreatedAt: base.Add(time.Duration(minutes) * time.Minute)}
+		if err := db.Create(&entry).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	keyset := Keyset{Sort: "-created_at", Column: "created_at", Desc: true, Time: true}
+	cursorAt := func(entry *domain.AuditLog, before bool) *Cursor {
+		return keyset.TimeCursor(entry.CreatedAt, entry.ID, before)
+	}
+	page := func(encoded string) (ids []uint, next, prev string) {
+		t.Helper()
+		var cursor *Cursor
+		query := db.Order(keyset.Order())
+		if encoded != "" {
+			var err error
+			if cursor, err = DecodeCursor(encoded); err != nil {
+				t.Fatal(err)
+			}
+			if query, err = keyset.Seek(db, cursor); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var entries []domain.AuditLog
+		if err := query.Limit(3).Find(&entries).Error; err != nil {
+			t.Fatal(err)
+		}
+		entries, next, prev = KeysetPage(entries, 2, cursor, cursorAt)
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		return ids, next, prev
+	}
+
+	// newest first, ties broken by id descending
+	want := [][]uint{{7, 6}, {5, 4}, {3, 2}, {1}}
+	var pages [][]uint
+	var prevs []string
+	for cursor := ""; ; {
+		ids, next, prev := page(cursor)
+		pages = append(pages, ids)
+		prevs = append(prevs, prev)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if !reflect.DeepEqual(pages, want) {
+		t.Fatalf("forward pages = %v, want %v", pages, want)
+	}
+	if prevs[0] != "" {
+		t.Errorf("first page has a previous cursor")
+	}
+
+	// and back again from the last page
+	pages = pages[:0]
+	for cursor := prevs[len(prevs)-1]; cursor != ""; {
+		ids, next, prev := page(cursor)
+		if next == "" {
+			t.Errorf("page %v before the last has no next cursor", ids)
+		}
+		pages = append([][]uint{ids}, pages...)
+		cursor = prev
+	}
+	if !reflect.DeepEqual(pages, want[:3]) {
+		t.Errorf("backward pages = %v, want %v", pages, want[:3])
+	}
+}
+
+func TestKeysetSeekRejectsForeignCursors(t *testing.T) {
+	db := dbtest.New(t)
+	keyset := Keyset{Sort: "-created_at", Column: "created_at", Desc: true, Time: true}
+	cursors := []*Cursor{
+		{Sort: "created_at", Value: "2024-02-01T10:00:00Z", ID: 1},
+		{Sort: "-created_at", Value: "yesterday", ID: 1},
+	}
+	for _, cursor := range cursors {
+		if _, err := keyset.Seek(db, cursor); err != ErrInvalidCursor {
+			t.Errorf("Seek(%+v) = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func TestKeysetSeekByID(t *testing.T) {
+	db := dbtest.New(t)
+	keyset := Keyset{Column: "id"}
+	stmt := func(cursor *Cursor) *gorm.Statement {
+		q, err := keyset.Seek(db.Session(&gorm.Session{DryRun: true}), cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return q.Find(&[]domain.AuditLog{}).Statement
+	}
+	after := stmt(&Cursor{ID: 4})
+	if sql := after.SQL.String(); !strings.Contains(sql, "id > ?") || !strings.HasSuffix(sql, "ORDER BY id asc") {
+		t.Errorf("after: %s", sql)
+	}
+	before := stmt(&Cursor{ID: 4, Before: true})
+	if sql := before.SQL.String(); !strings.Contains(sql, "id < ?") || !strings.HasSuffix(sql, "ORDER BY id desc") {
+		t.Errorf("before: %s", sql)
+	}
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -11,13 +12,35 @@ import (
 	"github.com/ostheperson/go-auth-service/internal/helper"
 )
 
+// ScopePasswordChange marks a restricted access token that may only be used
+// to replace an expired or administratively reset password.
+const ScopePasswordChange = "password_change"
+
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
 type JwtCustomClaims struct {
 	Username string      `json:"username"`
 	ID       uint        `json:"id"`
 	Role     domain.Role `json:"role"`
+	// SessionID is the refresh token row the access token was issued with.
+	SessionID uint        `json:"sid,omitempty"`
+	Scope     string      `json:"scope,omitempty"`
+	Act       *ActorClaim `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// ActorClaim is the RFC 8693 "act" claim. Nested actors are prior links in
+// the delegation chain, the outermost being the current actor.
+type ActorClaim struct {
+	Subject  string      `json:"sub"`
+	Username string      `json:"username,omitempty"`
+	Act      *ActorClaim `json:"act,omitempty"`
+}
+
 type JwtCustomRefreshClaims struct {
 	ID uint `json:"id"`
 	jwt.RegisteredClaims
@@ -27,12 +50,22 @@ func CreateAccessToken(
 	user *domain.User,
 	secret string,
 	expiry uint,
+) (accessToken string, err error) {
+	return CreateSessionAccessToken(user, 0, secret, expiry)
+}
+
+func CreateSessionAccessToken(
+	user *domain.User,
+	sessionID uint,
+	secret string,
+	expiry uint,
 ) (accessToken string, err error) {
 	exp := jwt.NewNumericDate(time.Now().Add(time.Duration(expiry) * time.Hour))
 	claims := &JwtCustomClaims{
-		Username: user.Username,
-		ID:       user.ID,
-		Role:     user.Role,
+		Username:  user.Username,
+		ID:        user.ID,
+		Role:      user.Role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: exp,
 		},
@@ -45,14 +78,39 @@ func CreateAccessToken(
 	return t, err
 }
 
+func CreatePasswordChangeToken(
+	user *domain.User,
+	secret string,
+	expiry time.Duration,
+) (string, error) {
+	claims := &JwtCustomClaims{
+		Username: user.Username,
+		ID:       user.ID,
+		Role:     user.Role,
+		Scope:    ScopePasswordChange,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+// CreateRefreshToken issues a refresh token with a random jti, so that two
+// sessions started within the same second still get distinct tokens.
 func CreateRefreshToken(
 	user *domain.User,
 	secret string,
 	expiry uint,
 ) (refreshToken string, err error) {
+	jti, err := GenerateSecret(16)
+	if err != nil {
+		return "", err
+	}
 	claimsRefresh := &JwtCustomRefreshClaims{
 		ID: user.ID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(expiry) * time.Hour)),
 		},
 	}
@@ -64,6 +122,66 @@ func CreateRefreshToken(
 	return rt, err
 }
 
+// CreateExchangedToken issues a token for audience on behalf of subject. When
+// actor is set it is recorded in the act claim ahead of any existing chain.
+// The token never outlives the subject token.
+func CreateExchangedToken(
+	subject *JwtCustomClaims,
+	actor *JwtCustomClaims,
+	audience string,
+	scope string,
+	secret string,
+	expiry uint,
+) (string, time.Time, error) {
+	exp := time.Now().Add(time.Duration(expiry) * time.Hour)
+	if subject.ExpiresAt != nil && subject.ExpiresAt.Time.Before(exp) {
+		exp = subject.ExpiresAt.Time
+	}
+	act := subject.Act
+	if actor != nil {
+		act = &ActorClaim{
+			Subject:  fmt.Sprint(actor.ID),
+			Username: actor.Username,
+			Act:      subject.Act,
+		}
+	}
+	claims := &JwtCustomClaims{
+		Username:  subject.Username,
+		ID:        subject.ID,
+		Role:      subject.Role,
+		SessionID: subject.SessionID,
+		Scope:     scope,
+		Act:       act,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   fmt.Sprint(subject.ID),
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(exp),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	t, err := token.SignedString([]byte(secret))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return t, exp, nil
+}
+
+// IsSubScope reports whether every scope in requested is also in granted.
+// An empty granted scope grants nothing.
+func IsSubScope(requested, granted string) bool {
+	allowed := make(map[string]bool)
+	for _, s := range strings.Fields(granted) {
+		allowed[s] = true
+	}
+	for _, s := range strings.Fields(requested) {
+		if !allowed[s] {
+			return false
+		}
+	}
+	return true
+}
+
 func IsAuthorized(requestToken string, secret string) (bool, error) {
 	_, err := jwt.Parse(requestToken, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
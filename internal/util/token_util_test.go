@@ -40,3 +40,55 @@ func TestVerifyAndExtractExpired(t *testing.T) {
 		return
 	}
 }
+
+func TestCreateExchangedToken(t *testing.T) {
+	secret := "secret"
+	user := domain.User{Username: "onion", ID: 1, Role: domain.UserRole}
+	serviceA := domain.User{Username: "service-a", ID: 2}
+	serviceB := domain.User{Username: "service-b", ID: 3}
+
+	subjectToken, _ := CreateAccessToken(&user, secret, 1)
+	subject, _ := VerifyAndExtract(subjectToken, secret)
+	actorToken, _ := CreateAccessToken(&serviceA, secret, 1)
+	actor, _ := VerifyAndExtract(actorToken, secret)
+
+	first, _, err := CreateExchangedToken(subject, actor, "service-b", "read write", secret, 1)
+	if err != nil {
+		t.Fatalf("error exchanging token: %v", err)
+	}
+	firstClaims, err := VerifyAndExtract(first, secret)
+	if err != nil {
+		t.Fatalf("VerifyAndExtract returned an error: %v", err)
+	}
+	if firstClaims.ID != user.ID || firstClaims.Act == nil || firstClaims.Act.Subject != "2" {
+		t.Fatalf("unexpected delegation claims: %+v", firstClaims)
+	}
+
+	// service B calls service C on behalf of the same user
+	actorToken, _ = CreateAccessToken(&serviceB, secret, 1)
+	actor, _ = VerifyAndExtract(actorToken, secret)
+	second, _, err := CreateExchangedToken(firstClaims, actor, "service-c", "read", secret, 1)
+	if err != nil {
+		t.Fatalf("error exchanging token: %v", err)
+	}
+	secondClaims, _ := VerifyAndExtract(second, secret)
+	if secondClaims.Act.Subject != "3" || secondClaims.Act.Act == nil ||
+		secondClaims.Act.Act.Subject != "2" {
+		t.Errorf("delegation chain not recorded: %+v", secondClaims.Act)
+	}
+	if len(secondClaims.Audience) != 1 || secondClaims.Audience[0] != "service-c" {
+		t.Errorf("unexpected audience %v", secondClaims.Audience)
+	}
+}
+
+func TestIsSubScope(t *testing.T) {
+	if IsSubScope("read", "") {
+		t.Error("an empty scope should not grant read")
+	}
+	if !IsSubScope("read", "read write") {
+		t.Error("read should be a subset of read write")
+	}
+	if IsSubScope("read admin", "read write") {
+		t.Error("admin should not be a subset of read write")
+	}
+}
@@ -1,9 +1,11 @@
 package util
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
-	"math/rand"
+	"math/big"
 	"strconv"
 	"time"
 
@@ -38,6 +40,26 @@ func GetPayload(c *gin.Context) (*JwtCustomClaims, error) {
 	return payload, nil
 }
 
+// GetIDParam parses the id path parameter. A value that isn't an unsigned
+// integer names no row, so callers answer it with 404.
+func GetIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	return uint(id), err == nil
+}
+
+const credentialFailureKey = "credential_failure"
+
+// MarkCredentialFailure records that the request was refused for wrong
+// credentials. ChallengeMiddleware only counts these towards challenging
+// the client.
+func MarkCredentialFailure(c *gin.Context) {
+	c.Set(credentialFailureKey, true)
+}
+
+func IsCredentialFailure(c *gin.Context) bool {
+	return c.GetBool(credentialFailureKey)
+}
+
 const (
 	charset     = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	hyphenIndex = 2
@@ -70,11 +92,26 @@ func ValidateStartAndEndTime(start, end time.Time) error {
 	return nil
 }
 
-func GenerateCode(length uint) string {
-	rand.New(rand.NewSource(time.Now().UnixNano()))
+// GenerateCode returns a code of length characters from charset, drawn from
+// crypto/rand without modulo bias.
+func GenerateCode(length uint) (string, error) {
 	code := make([]byte, length)
+	max := big.NewInt(int64(len(charset)))
 	for i := range code {
-		code[i] = charset[rand.Intn(len(charset))]
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = charset[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// GenerateSecret returns n bytes from crypto/rand encoded as unpadded base64url.
+func GenerateSecret(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	return string(code)
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
@@ -0,0 +1,31 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateCode(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code, err := GenerateCode(8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != 8 {
+			t.Fatalf("GenerateCode(8) = %q", code)
+		}
+		for _, r := range code {
+			if !strings.ContainsRune(charset, r) {
+				t.Fatalf("GenerateCode(8) = %q, %q is not in the charset", code, r)
+			}
+		}
+		if seen[code] {
+			t.Fatalf("GenerateCode(8) repeated %q", code)
+		}
+		seen[code] = true
+	}
+	if code, err := GenerateCode(0); err != nil || code != "" {
+		t.Errorf("GenerateCode(0) = %q, %v", code, err)
+	}
+}